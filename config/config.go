@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/golib/slices"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// StatusGroups are the status groups metrics are based on. Every Jira
+// status must be mapped to one of them in the configuration.
+var StatusGroups = []string{"backlog", "wip", "done", "resolved"}
+
+// Config describes how the Jira data from `jira_issues_events` is
+// mapped to the statuses, issue types and segments used by metrics.
+type Config struct {
+	Statuses   map[string][]string `yaml:"statuses"`    // status group -> Jira statuses
+	Waiting    []string            `yaml:"waiting"`     // Jira statuses of the `wip` group where no one works on the issue
+	IssueTypes map[string][]string `yaml:"issue_types"` // issue type group -> Jira issue types (underscored)
+	Segment    Segment             `yaml:"segment"`
+	IssuesAge  IssuesAge           `yaml:"issues_age"`
+
+	statusGroups    map[string]string // Jira status -> status group
+	waitingStatuses map[string]bool   // Jira status -> true if waiting
+	issueTypeGroups map[string]string // Jira issue type -> issue type group
+	backlogBuckets  []AgeBucket
+	wipBuckets      []AgeBucket
+}
+
+// Segment defines the column of `jira_issues_events` used to
+// segment metrics, and the prefix of the segment values.
+type Segment struct {
+	Column string `yaml:"column"`
+	Prefix string `yaml:"prefix"`
+}
+
+// IssuesAge defines the labels of the age buckets of backlog and WIP
+// issues (e.g. `3d`, `2w`, `1q`), ordered by age ascending.
+type IssuesAge struct {
+	Backlog []string `yaml:"backlog"`
+	WIP     []string `yaml:"wip"`
+}
+
+// AgeBucket is an issues age bucket, counting the issues younger than
+// `MaxAge` (and not counted in a previous bucket).
+type AgeBucket struct {
+	Label  string
+	MaxAge time.Duration
+}
+
+// DefaultAgeBuckets are the labels of the age buckets used when not
+// configured.
+var DefaultAgeBuckets = []string{"1d", "1w", "1m"}
+
+// ageUnits are the durations of the units of age bucket labels.
+var ageUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"m": 730 * time.Hour, // 365,25d / 12m --> 30,4d * 24h
+	"q": 3 * 730 * time.Hour,
+	"y": 12 * 730 * time.Hour,
+}
+
+var (
+	columnRegexp   = regexp.MustCompile("^[a-z_][a-z0-9_]*$")
+	ageLabelRegexp = regexp.MustCompile("^([1-9][0-9]*)([dwmqy])$")
+)
+
+// Load reads and parses the configuration file at `path`.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file `%s`: %s", path, err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates the passed YAML configuration.
+func Parse(data []byte) (*Config, error) {
+	c := Config{
+		Segment: Segment{
+			Column: "issue_tribe",
+			Prefix: "tribe",
+		},
+		IssuesAge: IssuesAge{
+			Backlog: DefaultAgeBuckets,
+			WIP:     DefaultAgeBuckets,
+		},
+	}
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing config: %s", err)
+	}
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	return &c, nil
+}
+
+// init validates the configuration and builds the reverse
+// mapping indices.
+func (c *Config) init() error {
+	if !columnRegexp.MatchString(c.Segment.Column) {
+		return fmt.Errorf("segment column `%s` is not a valid column name", c.Segment.Column)
+	}
+
+	c.statusGroups = make(map[string]string)
+	for group, statuses := range c.Statuses {
+		if !slices.StringsContain(StatusGroups, group) {
+			return fmt.Errorf("unknown status group `%s` (must be one of %v)", group, StatusGroups)
+		}
+		for _, status := range statuses {
+			if other, ok := c.statusGroups[status]; ok {
+				return fmt.Errorf("status `%s` is mapped to both `%s` and `%s`", status, other, group)
+			}
+			c.statusGroups[status] = group
+		}
+	}
+
+	c.waitingStatuses = make(map[string]bool)
+	for _, status := range c.Waiting {
+		if group := c.statusGroups[status]; group != "wip" {
+			return fmt.Errorf("waiting status `%s` is not mapped to `wip`", status)
+		}
+		c.waitingStatuses[status] = true
+	}
+
+	c.issueTypeGroups = make(map[string]string)
+	for group, issueTypes := range c.IssueTypes {
+		for _, issueType := range issueTypes {
+			if other, ok := c.issueTypeGroups[issueType]; ok {
+				return fmt.Errorf("issue type `%s` is mapped to both `%s` and `%s`", issueType, other, group)
+			}
+			c.issueTypeGroups[issueType] = group
+		}
+	}
+
+	var err error
+	if c.backlogBuckets, err = ParseAgeBuckets(c.IssuesAge.Backlog); err != nil {
+		return fmt.Errorf("invalid backlog age buckets: %s", err)
+	}
+	if c.wipBuckets, err = ParseAgeBuckets(c.IssuesAge.WIP); err != nil {
+		return fmt.Errorf("invalid wip age buckets: %s", err)
+	}
+	return nil
+}
+
+// ParseAgeBuckets parses age bucket labels, made of a number and a
+// unit (`d` for days, `w` for weeks, `m` for months, `q` for quarters,
+// `y` for years), e.g. `3d`. Labels must be ordered by age ascending.
+func ParseAgeBuckets(labels []string) ([]AgeBucket, error) {
+	buckets := make([]AgeBucket, 0, len(labels))
+	for i, label := range labels {
+		m := ageLabelRegexp.FindStringSubmatch(label)
+		if m == nil {
+			return nil, fmt.Errorf("`%s` is not a valid age (e.g. `3d`, `2w`, `1q`)", label)
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a valid age: %s", label, err)
+		}
+		bucket := AgeBucket{label, time.Duration(n) * ageUnits[m[2]]}
+		if i > 0 && bucket.MaxAge <= buckets[i-1].MaxAge {
+			return nil, fmt.Errorf("`%s` must be longer than `%s` (buckets must be in ascending order)", label, buckets[i-1].Label)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// StatusGroup returns the status group the Jira `status` is mapped
+// to. The boolean is false if the status is not mapped.
+func (c *Config) StatusGroup(status string) (string, bool) {
+	group, ok := c.statusGroups[status]
+	return group, ok
+}
+
+// BacklogAgeBuckets returns the age buckets of backlog issues.
+func (c *Config) BacklogAgeBuckets() []AgeBucket {
+	return c.backlogBuckets
+}
+
+// WIPAgeBuckets returns the age buckets of WIP issues.
+func (c *Config) WIPAgeBuckets() []AgeBucket {
+	return c.wipBuckets
+}
+
+// IsWaiting returns true if the Jira `status` is a waiting status,
+// i.e. a `wip` status where no one actively works on the issue.
+func (c *Config) IsWaiting(status string) bool {
+	return c.waitingStatuses[status]
+}
+
+// IssueTypeGroup returns the issue type group the (underscored) Jira
+// `issueType` is mapped to. The boolean is false if the issue type is
+// not mapped.
+func (c *Config) IssueTypeGroup(issueType string) (string, bool) {
+	group, ok := c.issueTypeGroups[issueType]
+	return group, ok
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAgeBuckets(t *testing.T) {
+	testCases := []struct {
+		labels   []string
+		expected []AgeBucket
+		err      bool
+	}{
+		{
+			labels: []string{"3d", "2w", "1q"},
+			expected: []AgeBucket{
+				{"3d", 3 * 24 * time.Hour},
+				{"2w", 14 * 24 * time.Hour},
+				{"1q", 3 * 730 * time.Hour},
+			},
+		},
+		{labels: []string{}, expected: []AgeBucket{}},
+		{labels: []string{"1w", "7d"}, err: true},
+		{labels: []string{"1w", "1d"}, err: true},
+		{labels: []string{"0d"}, err: true},
+		{labels: []string{"1h"}, err: true},
+		{labels: []string{"more"}, err: true},
+	}
+	for _, tc := range testCases {
+		buckets, err := ParseAgeBuckets(tc.labels)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", tc.labels)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", tc.labels, err)
+			continue
+		}
+		if len(buckets) != len(tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.labels, tc.expected, buckets)
+			continue
+		}
+		for i := range buckets {
+			if buckets[i] != tc.expected[i] {
+				t.Errorf("%v: expected %v, got %v", tc.labels, tc.expected, buckets)
+			}
+		}
+	}
+}
+
+func TestParseIssuesAgeDefaults(t *testing.T) {
+	c, err := Parse([]byte("issues_age:\n  wip: [3d, 2w]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.BacklogAgeBuckets()); n != len(DefaultAgeBuckets) {
+		t.Errorf("expected the default backlog buckets, got %d buckets", n)
+	}
+	if wip := c.WIPAgeBuckets(); len(wip) != 2 || wip[1].Label != "2w" {
+		t.Errorf("expected the configured wip buckets, got %v", wip)
+	}
+
+	if _, err := Parse([]byte("issues_age:\n  backlog: [1m, 1w]\n")); err == nil {
+		t.Error("expected an error for buckets not in ascending order")
+	}
+}
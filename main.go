@@ -1,121 +1,455 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
-	"sync"
+	"strconv"
+	"time"
 
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rchampourlier/kaizenizer/config"
 	"github.com/rchampourlier/kaizenizer/metrics"
 	"github.com/rchampourlier/kaizenizer/store"
 )
 
 const poolSize = 10
 
-// MaxOpenConns defines the maximum number of open connections
-// to the DB.
-const MaxOpenConns = 5 // for Heroku Postgres
+// DefaultConfigPath is the path of the configuration file used
+// when `CONFIG_PATH` is not set.
+const DefaultConfigPath = "config.yml"
+
+// DefaultForecastRuns is the default number of Monte Carlo
+// simulations run per segment by `forecast`.
+const DefaultForecastRuns = 10000
+
+// DefaultMaxOpenConns is the maximum number of open connections to
+// each DB when `SOURCE_DB_MAX_OPEN_CONNS` or `SINK_DB_MAX_OPEN_CONNS`
+// is not set.
+const DefaultMaxOpenConns = 5 // for Heroku Postgres
 
 // Main program
 //
+// Events are read from the source database (`SOURCE_DB_URL`) and
+// everything else is written to the sink database (`SINK_DB_URL`),
+// both falling back to `DB_URL`. The maximum number of open
+// connections to each is set by `SOURCE_DB_MAX_OPEN_CONNS` and
+// `SINK_DB_MAX_OPEN_CONNS`.
+//
 // ### generate
 //
 // Calculate metrics.
 //
-// 1. Initializes the database (drops the necessary table `metrics`
-//    if it exists and creates it).
-// 2. Processes Jira data (from `jira_issues_events`) and generate
-//    metrics.
+// 1. Initializes the sink database (creates the `metrics`, `mapping_report`
+//    and `checkpoints` tables if they don't exist, and the `metrics_new`
+//    staging table).
+// 2. Processes Jira data (from the source's `jira_issues_events`) for all projects
+//    and generate metrics into `metrics_new`.
+// 3. Reports the statuses and issue types which did not match the
+//    configuration to the `mapping_report` table.
+// 4. Replaces `metrics` with `metrics_new` and saves the generators'
+//    state in the `checkpoints` table in a transaction. If the
+//    generation fails, `metrics` is left untouched.
+//
+// With `--strict`, the first unmapped value stops the program instead.
+//
+// With `--check-invariants`, the metrics are checked for consistency as
+// they are generated (e.g. the WIP counters per issue type add up to
+// the WIP counter). Every violation is logged with its segment and
+// time, and the program fails before replacing `metrics`.
+//
+// Any error stops the generation and makes the program exit with a
+// non-zero status, leaving `metrics` and `checkpoints` untouched.
+//
+// With `--incremental`, the generators' state is restored from the
+// checkpoint and only the events which happened after it are
+// processed. The new metrics are appended from `metrics_new` to
+// `metrics` in the same transaction as the checkpoint is saved. If
+// there is no checkpoint, it runs as a full generation.
+//
+// ### forecast
+//
+// Forecasts the completion date of the issues currently in the backlog
+// of each segment, running Monte Carlo simulations based on the daily
+// throughput of the last 90 days. The 50th, 85th and 95th percentiles
+// of the simulations' completion dates are written to the `forecasts`
+// table, replacing the previous forecasts.
+//
+// ### audit
+//
+// Replays all events to find data quality problems (status mismatches,
+// negative counters, out of order events, events after resolution and
+// unmapped values). They are written to the `data_quality` table,
+// replacing the previous audit.
 //
 // ### cleanup
 //
-// Drops the `metrics` table.
+// Drops the `metrics`, `metrics_new`, `mapping_report`, `checkpoints`,
+// `forecasts` and `data_quality` tables.
 //
 func main() {
 	if len(os.Args) < 2 {
 		usage()
 	}
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		log.Printf("[main] %s failed: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
 
-	db := openDB()
-	defer db.Close()
-	s := store.NewPGStore(db)
+func run(action string, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	source, err := openDB("SOURCE")
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	sink, err := openDB("SINK")
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	s := store.NewPGStore(source, sink, cfg)
 
-	switch os.Args[1] {
+	switch action {
 
 	case "generate":
-		s.DropTables()
-		s.CreateTables()
-		generateMetrics(
-			s,
-			s.StreamEvents("issue_project", "JobTeaser"),
-			"jt",
-		)
+		fs := flag.NewFlagSet("generate", flag.ExitOnError)
+		strict := fs.Bool("strict", false, "fail on the first status or issue type not matching the configuration")
+		incremental := fs.Bool("incremental", false, "resume from the last checkpoint and only append new metrics")
+		checkInvariants := fs.Bool("check-invariants", false, "check the consistency of the generated metrics")
+		fs.Parse(args)
+		s.SetStrict(*strict)
+		return generate(s, newGenerators(cfg), *incremental, *checkInvariants)
+
+	case "forecast":
+		fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+		runs := fs.Int("runs", DefaultForecastRuns, "number of simulations per segment")
+		fs.Parse(args)
+		if *runs < 1 {
+			return fmt.Errorf("invalid `--runs` (%d): at least 1 simulation is required", *runs)
+		}
+		return forecast(s, *runs)
+
+	case "audit":
+		return audit(s)
 
 	case "cleanup":
-		s.DropTables()
+		return s.DropTables()
 
 	default:
 		usage()
 	}
+	return nil
 }
 
-func generateMetrics(s *store.PGStore, events chan store.Event, segmentPrefix string) {
-	wgGenerators := sync.WaitGroup{}
+func generate(s *store.PGStore, generators map[string]metrics.Generator, incremental, checkInvariants bool) error {
+	var since time.Time
+	var resumed bool
+	if err := s.CreateTables(); err != nil {
+		return err
+	}
+	if incremental {
+		var err error
+		if since, resumed, err = restoreCheckpoint(s, generators); err != nil {
+			return err
+		}
+	}
+	if err := s.UseStagingTable(); err != nil {
+		return err
+	}
+
+	var w store.MetricWriter = s
+	var checker *metrics.InvariantChecker
+	if checkInvariants {
+		checker = metrics.NewInvariantChecker(s)
+		w = checker
+	}
+
+	lastEvent, err := generateMetrics(s, w, generators, since)
+	// Tell it's done and wait for everything to be written, also
+	// after an error so no batch is left half-written.
+	if doneErr := s.DoneAndWait(); err == nil {
+		err = doneErr
+	}
+	if err != nil {
+		return err
+	}
+	if checker != nil {
+		if err := checker.Check(); err != nil {
+			return err
+		}
+	}
 
-	// TODO: initialize the generator with the events chan
-	metricsGenerators := []metrics.Generator{
-		metrics.NewLeadAndCycleTime(),
-		metrics.NewCounters(),
-		metrics.NewIssuesAge(),
+	if lastEvent.IsZero() {
+		lastEvent = since
+	}
+	c, err := newCheckpoint(generators, lastEvent)
+	if err != nil {
+		return err
 	}
-	wgGenerators.Add(3)
 
-	eventsChans := make([](chan store.Event), len(metricsGenerators))
-	metricsChans := make([](chan store.Metric), len(metricsGenerators))
-	for i, gen := range metricsGenerators {
-		eventsChans[i] = make(chan store.Event, 0)
-		metricsChans[i] = make(chan store.Metric, 0)
+	if err := s.WriteMappingReport(); err != nil {
+		return err
+	}
+	if resumed {
+		return s.AppendStagingTable(c)
+	}
+	// Without a checkpoint, all events were processed: appending
+	// would duplicate the existing metrics.
+	return s.SwapStagingTable(c)
+}
+
+// newGenerators returns the metrics generators, by name. The
+// name identifies the generator's state in checkpoints.
+func newGenerators(cfg *config.Config) map[string]metrics.Generator {
+	return map[string]metrics.Generator{
+		"lead_and_cycle_time": metrics.NewLeadAndCycleTime(),
+		"counters":            metrics.NewCounters(),
+		"issues_age":          metrics.NewIssuesAge(cfg.BacklogAgeBuckets(), cfg.WIPAgeBuckets()),
+		"throughput":          metrics.NewThroughput(),
+		"contributors":        metrics.NewContributors(),
+		"time_in_status":      metrics.NewTimeInStatus(),
+		"flow_efficiency":     metrics.NewFlowEfficiency(),
+		"rework":              metrics.NewRework(),
+		"aging_wip":           metrics.NewAgingWIP(),
+		"littles_law":         metrics.NewLittlesLaw(),
+	}
+}
+
+// generateMetrics streams the events which happened after `since`
+// from `source` to the generators, which write metrics to `w`. Returns
+// the time of the last event.
+//
+// The source and the generators run in an `errgroup`: the first
+// error cancels the stream and the other generators, and is returned.
+// Once cancelled, writes to `w` are refused so the other generators
+// stop without writing their end-of-run metrics (a generator can't
+// tell its events channel was closed because of an error).
+func generateMetrics(source store.EventSource, w store.MetricWriter, generators map[string]metrics.Generator, since time.Time) (time.Time, error) {
+	g, ctx := errgroup.WithContext(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w = cancellableWriter{ctx, w}
 
-		go func(ms chan store.Metric) {
-			for m := range ms {
-				s.WriteMetric(store.Metric(m))
+	events := make(chan store.Event, 0)
+	streamErr := make(chan error, 1)
+	g.Go(func() error {
+		err := source.StreamEvents(ctx, since, events)
+		streamErr <- err
+		return err
+	})
+
+	eventsChans := make([](chan store.Event), 0, len(generators))
+	for name, gen := range generators {
+		eventsChan := make(chan store.Event, 0)
+		eventsChans = append(eventsChans, eventsChan)
+
+		name, gen := name, gen
+		g.Go(func() error {
+			if err := gen.Generate(eventsChan, w); err != nil {
+				return fmt.Errorf("error in generator `%s`: %s", name, err)
+			}
+			return nil
+		})
+	}
+
+	var lastEvent time.Time
+	g.Go(func() error {
+		defer func() {
+			for _, eventsChan := range eventsChans {
+				close(eventsChan)
+			}
+		}()
+		for evt := range events {
+			lastEvent = evt.Time
+			for _, eventsChan := range eventsChans {
+				select {
+				case eventsChan <- evt:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
-		}(metricsChans[i])
+		}
+		// `events` is also closed when the stream fails: cancelling
+		// before closing the generators' channels in that case.
+		if err := <-streamErr; err != nil {
+			cancel()
+			return err
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	return lastEvent, err
+}
+
+// cancellableWriter is a `store.MetricWriter` refusing writes once
+// `ctx` is done.
+type cancellableWriter struct {
+	ctx context.Context
+	w   store.MetricWriter
+}
+
+// WriteMetric writes the metric to the underlying writer, or returns
+// the context's error if it is done.
+func (c cancellableWriter) WriteMetric(metric store.Metric) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.w.WriteMetric(metric)
+}
+
+// forecast computes the forecasts from all events and writes them
+// to the `forecasts` table.
+func forecast(s *store.PGStore, runs int) error {
+	if err := s.CreateTables(); err != nil {
+		return err
+	}
+	f := metrics.NewForecaster(runs, rand.New(rand.NewSource(time.Now().UnixNano())))
+
+	var forecasts []store.Forecast
+	err := replayEvents(s, func(events chan store.Event) {
+		forecasts = f.Forecast(events)
+	})
+	if err != nil {
+		return err
+	}
+	return s.WriteForecasts(forecasts)
+}
+
+// audit finds the anomalies in all events and writes them to the
+// `data_quality` table.
+func audit(s *store.PGStore) error {
+	if err := s.CreateTables(); err != nil {
+		return err
+	}
+	a := metrics.NewAuditor()
 
-		go func(g metrics.Generator, j int) {
-			g.Generate(eventsChans[j], segmentPrefix, s)
-			wgGenerators.Done()
-		}(gen, i)
+	var anomalies []store.Anomaly
+	err := replayEvents(s, func(events chan store.Event) {
+		anomalies = a.Audit(events)
+	})
+	if err != nil {
+		return err
 	}
-	for evt := range events {
-		for _, eventsChan := range eventsChans {
-			eventsChan <- store.Event(evt)
+	return s.WriteAnomalies(anomalies)
+}
+
+// replayEvents streams all events from `source` to `process`, which
+// must read the channel until it's closed.
+func replayEvents(source store.EventSource, process func(events chan store.Event)) error {
+	g, ctx := errgroup.WithContext(context.Background())
+	events := make(chan store.Event, 0)
+	g.Go(func() error {
+		return source.StreamEvents(ctx, time.Time{}, events)
+	})
+	g.Go(func() error {
+		process(events)
+		return nil
+	})
+	return g.Wait()
+}
+
+// restoreCheckpoint restores the generators' state from the last
+// checkpoint and returns the time of the last event processed, and
+// whether the generators' state was restored. Returns a zero time and
+// false if there is no checkpoint.
+func restoreCheckpoint(s *store.PGStore, generators map[string]metrics.Generator) (time.Time, bool, error) {
+	c, err := s.ReadCheckpoint()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if c == nil {
+		log.Printf("[main] no checkpoint found, processing all events\n")
+		return time.Time{}, false, nil
+	}
+	for name, gen := range generators {
+		state, ok := c.States[name]
+		if !ok {
+			return time.Time{}, false, fmt.Errorf("no checkpoint for generator `%s`, run a full generation first", name)
 		}
+		if err := gen.UnmarshalState(state); err != nil {
+			return time.Time{}, false, fmt.Errorf("error restoring generator `%s`: %s", name, err)
+		}
+	}
+	log.Printf("[main] resuming from checkpoint at %s\n", c.EventTime.Format(time.RFC3339))
+	return c.EventTime, true, nil
+}
+
+// newCheckpoint returns a checkpoint of the generators' state after
+// processing the events until `lastEvent`.
+func newCheckpoint(generators map[string]metrics.Generator, lastEvent time.Time) (store.Checkpoint, error) {
+	c := store.Checkpoint{
+		EventTime: lastEvent,
+		States:    make(map[string][]byte),
 	}
-	for _, eventsChan := range eventsChans {
-		close(eventsChan)
+	for name, gen := range generators {
+		state, err := gen.MarshalState()
+		if err != nil {
+			return c, fmt.Errorf("error saving generator `%s`: %s", name, err)
+		}
+		c.States[name] = state
 	}
-	wgGenerators.Wait()
-	s.DoneAndWait() // tell it's done and wait for everything to be written
+	return c, nil
 }
 
 func usage() {
-	fmt.Printf(`Usage: go run main.go <action>
+	fmt.Printf(`Usage: go run main.go <action> [options]
 
 Available actions:
-  - generate (generate metrics)
+  - generate [--strict] [--incremental] [--check-invariants] (generate metrics)
+      --strict: fail on the first unmapped status or issue type
+      --incremental: resume from the last checkpoint and append new metrics
+      --check-invariants: fail if the generated metrics are inconsistent
+  - forecast [--runs N] (forecast the completion of the backlogs)
+      --runs: number of simulations per segment (default 10000)
+  - audit (report data quality problems)
   - cleanup (cleans the database)
 `)
 	os.Exit(1)
 }
 
-func openDB() *sql.DB {
-	connStr := os.Getenv("DB_URL")
+func loadConfig() (*config.Config, error) {
+	path := os.Getenv("CONFIG_PATH")
+	if path == "" {
+		path = DefaultConfigPath
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in `loadConfig`: %s", err)
+	}
+	return cfg, nil
+}
+
+// openDB opens the `SOURCE` or `SINK` DB, whose URL is set by
+// `<name>_DB_URL` (or `DB_URL` if not set) and maximum number of open
+// connections by `<name>_DB_MAX_OPEN_CONNS`.
+func openDB(name string) (*sql.DB, error) {
+	connStr := os.Getenv(name + "_DB_URL")
+	if connStr == "" {
+		connStr = os.Getenv("DB_URL")
+	}
+	maxOpenConns := DefaultMaxOpenConns
+	if v := os.Getenv(name + "_DB_MAX_OPEN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("error in `openDB`: invalid `%s_DB_MAX_OPEN_CONNS` (%s)", name, v)
+		}
+		maxOpenConns = n
+	}
 	db, err := sql.Open("postgres", connStr)
-	db.SetMaxOpenConns(MaxOpenConns)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("[main] error in `openDB`: %s", err))
+		return nil, fmt.Errorf("error in `openDB`: %s", err)
 	}
-	return db
+	db.SetMaxOpenConns(maxOpenConns)
+	return db, nil
 }
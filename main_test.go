@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/metrics"
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// failingGenerator fails after receiving its first event.
+type failingGenerator struct {
+	metrics.Generator
+}
+
+func (g failingGenerator) Generate(events chan store.Event, w store.MetricWriter) error {
+	<-events
+	return errors.New("failure")
+}
+
+// failingSource sends its events, then fails.
+type failingSource struct {
+	events []store.Event
+}
+
+func (s failingSource) StreamEvents(ctx context.Context, since time.Time, events chan<- store.Event) error {
+	defer close(events)
+	for _, evt := range s.events {
+		select {
+		case events <- evt:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.New("stream failure")
+}
+
+func testEvents(n int) []store.Event {
+	t0 := time.Date(2018, 6, 4, 0, 0, 0, 0, time.UTC)
+	events := make([]store.Event, n)
+	for i := range events {
+		events[i] = store.Event{
+			Time:      t0.Add(time.Duration(i) * time.Hour),
+			Kind:      store.StatusChanged,
+			IssueKey:  "A",
+			IssueType: "bug",
+			Segment:   "p/tribe_a",
+			ValueFrom: "backlog",
+			ValueTo:   "wip",
+		}
+	}
+	return events
+}
+
+func TestGenerateMetrics(t *testing.T) {
+	events := testEvents(100)
+	s := store.NewMemStore(events)
+	generators := map[string]metrics.Generator{
+		"counters":            metrics.NewCounters(),
+		"lead_and_cycle_time": metrics.NewLeadAndCycleTime(),
+	}
+
+	lastEvent, err := generateMetrics(s, s, generators, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lastEvent.Equal(events[99].Time) {
+		t.Errorf("expected last event at %s, got %s", events[99].Time, lastEvent)
+	}
+	if len(s.Metrics()) == 0 {
+		t.Errorf("expected metrics to be written")
+	}
+}
+
+func TestGenerateMetricsFailure(t *testing.T) {
+	s := store.NewMemStore(testEvents(100))
+	generators := map[string]metrics.Generator{
+		"counters": metrics.NewCounters(),
+		"failing":  failingGenerator{},
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := generateMetrics(s, s, generators, time.Time{})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "`failing`") {
+			t.Errorf("expected the failing generator's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("generateMetrics did not return after a generator failed")
+	}
+}
+
+func TestGenerateMetricsStreamFailure(t *testing.T) {
+	events := testEvents(10)
+	for i := range events {
+		events[i].ValueTo = "resolved"
+	}
+	w := store.NewMemStore(nil)
+	generators := map[string]metrics.Generator{
+		"lead_and_cycle_time": metrics.NewLeadAndCycleTime(),
+	}
+
+	_, err := generateMetrics(failingSource{events}, w, generators, time.Time{})
+	if err == nil || err.Error() != "stream failure" {
+		t.Errorf("expected the stream's error, got %v", err)
+	}
+	// The lead times are only written once all events are processed
+	if n := len(w.Metrics()); n != 0 {
+		t.Errorf("expected no metrics to be written after the failure, got %d", n)
+	}
+}
@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Number of days of cycle time history the aging WIP is compared to.
+var agingWIPHistoryDays = 90
+
+// AgingWIP implements `Generator` for the _Aging WIP_ metrics.
+type AgingWIP struct {
+	clock  dayClock
+	issues map[string]cycleIssue  // issue key -> issue
+	cycles map[string][]dataPoint // segment -> cycle times of the issues done in the history
+}
+
+// cycleIssue is the current status and segment of an issue, and the
+// start of its cycle.
+type cycleIssue struct {
+	Status     string
+	Segment    string
+	CycleStart time.Time // time the issue entered `wip` for the first time
+}
+
+// NewAgingWIP returns an `AgingWIP` struct initialized with internal
+// data.
+func NewAgingWIP() *AgingWIP {
+	return &AgingWIP{
+		issues: make(map[string]cycleIssue),
+		cycles: make(map[string][]dataPoint),
+	}
+}
+
+// Generate generates metrics flagging the WIP issues whose age (time
+// since they entered `wip` for the first time) exceeds the cycle time
+// percentiles of their segment, for the issues done in the last
+// `agingWIPHistoryDays` days.
+//
+// Generated metrics, every day, for each flagged issue (the value is
+// the issue's age in days at the end of the day):
+//   - Issues older than the p85 cycle time --> name=aging_wip/overdue,
+//     comment=<issue key>
+//   - Issues older than the p50 cycle time (but not the p85)
+//     --> name=aging_wip/at_risk, comment=<issue key>
+//
+// Issues of segments without any issue done in the history are not
+// flagged.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *AgingWIP) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged && evt.Kind != store.IssueCreated {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		issue := g.issues[evt.IssueKey]
+		issue.Status, issue.Segment = evt.ValueTo, evt.Segment
+		if evt.ValueTo == "wip" && issue.CycleStart.IsZero() {
+			issue.CycleStart = evt.Time
+		}
+		if evt.ValueTo == "done" && !issue.CycleStart.IsZero() {
+			cycleTime := float64(evt.Time.Sub(issue.CycleStart)) / float64(oneDay)
+			g.cycles[evt.Segment] = append(g.cycles[evt.Segment], dataPoint{evt.Time, cycleTime})
+		}
+		g.issues[evt.IssueKey] = issue
+	}
+
+	log.Printf("[metrics/aging_wip] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushMetricsForDay pushes the flagged issues for the day `d` which
+// just ended. Returns the number of metrics pushed.
+func (g *AgingWIP) pushMetricsForDay(d time.Time, w store.MetricWriter) (int, error) {
+	countMetrics := 0
+	end := d.Add(oneDay)
+	g.forgetCyclesBefore(end.Add(-time.Duration(agingWIPHistoryDays) * oneDay))
+
+	p50, p85 := make(map[string]float64), make(map[string]float64)
+	for segment, cycles := range g.cycles {
+		values := make([]float64, len(cycles))
+		for i, c := range cycles {
+			values[i] = c.value
+		}
+		sort.Float64s(values)
+		p50[segment], p85[segment] = percentile(values, 50), percentile(values, 85)
+	}
+
+	for key, issue := range g.issues {
+		if issue.Status != "wip" {
+			continue
+		}
+		if _, ok := p50[issue.Segment]; !ok {
+			continue
+		}
+
+		age := float64(end.Sub(issue.CycleStart)) / float64(oneDay)
+		var name string
+		switch {
+		case age > p85[issue.Segment]:
+			name = "aging_wip/overdue"
+		case age > p50[issue.Segment]:
+			name = "aging_wip/at_risk"
+		default:
+			continue
+		}
+		err := w.WriteMetric(store.Metric{
+			Time:    d,
+			Name:    name,
+			Segment: issue.Segment,
+			Value:   age,
+			Comment: key,
+		})
+		if err != nil {
+			return countMetrics, err
+		}
+		countMetrics++
+	}
+	return countMetrics, nil
+}
+
+// forgetCyclesBefore removes the cycle times of issues done before
+// `t`.
+func (g *AgingWIP) forgetCyclesBefore(t time.Time) {
+	for segment, cycles := range g.cycles {
+		kept := make([]dataPoint, 0, len(cycles))
+		for _, c := range cycles {
+			if !c.time.Before(t) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(g.cycles, segment)
+			continue
+		}
+		g.cycles[segment] = kept
+	}
+}
+
+type agingWIPState struct {
+	Clock  dayClock
+	Issues map[string]cycleIssue
+	Cycles map[string][]dataPoint
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *AgingWIP) MarshalState() ([]byte, error) {
+	return json.Marshal(agingWIPState{g.clock, g.issues, g.cycles})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *AgingWIP) UnmarshalState(data []byte) error {
+	var state agingWIPState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.issues, g.cycles = state.Clock, state.Issues, state.Cycles
+	return nil
+}
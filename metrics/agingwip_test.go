@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestAgingWIP(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "issues at risk and overdue",
+			events: []store.Event{
+				statusChange(at(0, 0), "A", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(0, 0), "B", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(0, 12), "C", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "A", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(2, 0), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(2, 0), "D", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(2, 0), "E", "bug", "tribe_b", "backlog", "wip"),
+				statusChange(at(4, 0), "F", "bug", "tribe_a", "backlog", "wip"),
+			},
+			expected: []string{
+				// p50 = p85 = 1 on 06-05, p50 = 1 and p85 = 2 after
+				"06-05T00 aging_wip/overdue p/tribe_a 2 B",
+				"06-05T00 aging_wip/overdue p/tribe_a 1.5 C",
+				"06-06T00 aging_wip/overdue p/tribe_a 2.5 C",
+				"06-07T00 aging_wip/overdue p/tribe_a 3.5 C",
+				"06-07T00 aging_wip/at_risk p/tribe_a 2 D",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewAgingWIP(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Auditor replays events to find data quality problems, such as
+// status histories not matching the backlog -> wip -> done -> resolved
+// workflow, which make metrics wrong.
+//
+// It is not a `Generator`: anomalies are reported once all events have
+// been processed.
+type Auditor struct {
+	statuses  map[string]string         // issue key -> status group
+	resolved  map[string]bool           // issue key -> true if the issue has been resolved
+	counters  map[string]map[string]int // status group -> segment -> count, following `ValueFrom` and `ValueTo`
+	anomalies []store.Anomaly
+}
+
+// NewAuditor returns an `Auditor` struct initialized with internal
+// data.
+func NewAuditor() *Auditor {
+	return &Auditor{
+		statuses:  make(map[string]string),
+		resolved:  make(map[string]bool),
+		counters:  make(map[string]map[string]int),
+		anomalies: make([]store.Anomaly, 0),
+	}
+}
+
+// Audit processes the `events` and returns the anomalies found:
+//   - status mismatches: status changes from a status group which is
+//     not the one the issue was in
+//   - negative counters: the number of issues in `backlog` or `wip`
+//     per segment, counted from the status changes' `from` and `to`
+//     groups (and moved on segment changes), goes below 0
+//   - out of order events: status changes happening before the
+//     issue's creation
+//   - events after resolution: status changes of resolved issues
+//   - unmapped values: statuses and issue types not matching the
+//     configuration
+func (a *Auditor) Audit(events chan store.Event) []store.Anomaly {
+	for evt := range events {
+		switch evt.Kind {
+		case store.IssueCreated:
+			a.statuses[evt.IssueKey] = evt.ValueTo
+			a.count(evt.ValueTo, evt.Segment, 1, evt)
+			a.checkStatusMapping(evt)
+			a.checkIssueTypeMapping(evt)
+
+		case store.IssueTypeChanged:
+			a.checkIssueTypeMapping(evt)
+
+		case store.SegmentChanged:
+			// Moving the issue to the counter of its new segment
+			if status, ok := a.statuses[evt.IssueKey]; ok {
+				a.count(status, evt.ValueFrom, -1, evt)
+				a.count(status, evt.ValueTo, 1, evt)
+			}
+
+		case store.StatusChanged, store.StatusChangedInGroup:
+			if evt.Time.Before(evt.IssueCreatedAt) {
+				a.report(evt, store.OutOfOrder, "status changed before the issue's creation (%s)", evt.IssueCreatedAt.Format(time.RFC3339))
+			}
+			if was, ok := a.statuses[evt.IssueKey]; ok && was != evt.ValueFrom {
+				a.report(evt, store.StatusMismatch, "status changed from `%s` but was `%s`", evt.ValueFrom, was)
+			}
+			if a.resolved[evt.IssueKey] {
+				a.report(evt, store.EventAfterResolution, "status changed from `%s` to `%s` after the issue was resolved", evt.StatusFrom, evt.StatusTo)
+			}
+			a.statuses[evt.IssueKey] = evt.ValueTo
+			if evt.ValueTo == "resolved" {
+				a.resolved[evt.IssueKey] = true
+			}
+			a.count(evt.ValueFrom, evt.Segment, -1, evt)
+			a.count(evt.ValueTo, evt.Segment, 1, evt)
+			a.checkStatusMapping(evt)
+		}
+	}
+
+	log.Printf("[metrics/audit] %d anomalies found\n", len(a.anomalies))
+	return a.anomalies
+}
+
+// count adds `delta` to the counter of the status group for `segment`
+// and reports the event if the counter becomes negative.
+func (a *Auditor) count(status, segment string, delta int, evt store.Event) {
+	if status != "backlog" && status != "wip" {
+		return
+	}
+	if _, ok := a.counters[status]; !ok {
+		a.counters[status] = make(map[string]int)
+	}
+	a.counters[status][segment] += delta
+	if value := a.counters[status][segment]; value < 0 {
+		a.report(evt, store.NegativeCounter, "`%s` counter of %s is %d (the issue left a status it was not counted in)", status, segment, value)
+	}
+}
+
+// checkStatusMapping reports the event if the issue's new status is
+// not mapped in the configuration. The status it changed from was
+// checked with the previous event of the issue.
+func (a *Auditor) checkStatusMapping(evt store.Event) {
+	if evt.ValueTo == store.Unmapped {
+		a.report(evt, store.UnmappedValue, "status `%s` is not mapped", evt.StatusTo)
+	}
+}
+
+// checkIssueTypeMapping reports the event if the issue type is not
+// mapped in the configuration.
+func (a *Auditor) checkIssueTypeMapping(evt store.Event) {
+	if evt.IssueType == store.Unmapped {
+		a.report(evt, store.UnmappedValue, "issue type is not mapped")
+	}
+}
+
+func (a *Auditor) report(evt store.Event, kind, format string, args ...interface{}) {
+	a.anomalies = append(a.anomalies, store.Anomaly{
+		Time:        evt.Time,
+		Kind:        kind,
+		IssueKey:    evt.IssueKey,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
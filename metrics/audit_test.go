@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestAuditor(t *testing.T) {
+	unmapped := withStatuses(statusChange(at(4, 0), "D", "bug", "tribe_a", "backlog", store.Unmapped), "open", "foo")
+	events := []store.Event{
+		issueCreated(at(0, 0), "A", "bug", "tribe_a", "backlog"),
+		statusChange(at(1, 0), "A", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(2, 0), "B", "bug", "tribe_a", "wip", "resolved"),
+		createdAt(statusChange(at(2, 12), "C", "bug", "tribe_b", "backlog", "wip"), at(2, 18)),
+		withStatuses(statusChange(at(3, 0), "B", "bug", "tribe_a", "resolved", "resolved"), "closed", "released"),
+		unmapped,
+	}
+	assertAnomalies(t, audit(events), []string{
+		"06-05T00 A status_mismatch: status changed from `wip` but was `backlog`",
+		"06-05T00 A negative_counter: `wip` counter of p/tribe_a is -1 (the issue left a status it was not counted in)",
+		"06-06T00 B negative_counter: `wip` counter of p/tribe_a is -2 (the issue left a status it was not counted in)",
+		"06-06T12 C out_of_order: status changed before the issue's creation (2018-06-06T18:00:00Z)",
+		"06-06T12 C negative_counter: `backlog` counter of p/tribe_b is -1 (the issue left a status it was not counted in)",
+		"06-07T00 B event_after_resolution: status changed from `closed` to `released` after the issue was resolved",
+		"06-08T00 D unmapped_value: status `foo` is not mapped",
+	})
+}
+
+func TestAuditorSegmentChanged(t *testing.T) {
+	events := []store.Event{
+		issueCreated(at(0, 0), "A", "bug", "tribe_a", "backlog"),
+		segmentChange(at(1, 0), "A", "bug", "tribe_a", "tribe_b"),
+		statusChange(at(2, 0), "A", "bug", "tribe_b", "backlog", "wip"),
+	}
+	assertAnomalies(t, audit(events), []string{})
+}
+
+// audit returns the anomalies found in `events`, formatted to be
+// compared with `assertAnomalies`.
+func audit(events []store.Event) []string {
+	eventsChan := make(chan store.Event, len(events))
+	for _, evt := range events {
+		eventsChan <- evt
+	}
+	close(eventsChan)
+
+	anomalies := make([]string, 0)
+	for _, a := range NewAuditor().Audit(eventsChan) {
+		anomalies = append(anomalies, fmt.Sprintf("%s %s %s: %s", a.Time.Format("01-02T15"), a.IssueKey, a.Kind, a.Description))
+	}
+	return anomalies
+}
+
+func assertAnomalies(t *testing.T, actual, expected []string) {
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected anomalies\nexpected:\n  %s\nactual:\n  %s",
+			strings.Join(expected, "\n  "),
+			strings.Join(actual, "\n  "),
+		)
+	}
+}
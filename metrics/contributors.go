@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Contributors implements `Generator` for the _WIP per contributor_
+// metric.
+type Contributors struct {
+	clock  dayClock
+	issues map[string]contributorIssue // issue key -> issue
+}
+
+type contributorIssue struct {
+	Assignee string
+	Status   string
+	Segment  string
+}
+
+// NewContributors returns a `Contributors` struct initialized with
+// internal data.
+func NewContributors() *Contributors {
+	return &Contributors{
+		issues: make(map[string]contributorIssue),
+	}
+}
+
+// Generate generates metrics on the WIP of contributors, based on the
+// status and assignee of issues.
+//
+// Generated metrics, every day, per segment:
+//   - WIP per contributor: number of WIP issues assigned to each
+//     contributor --> name=contributors/wip, comment=<assignee>
+//   - WIP to contributor ratio: number of WIP issues (assigned or not)
+//     divided by the number of contributors with WIP issues
+//     --> name=contributors/wip_ratio
+//
+// Metrics for a day are pushed once an event from a later day has been
+// received, with the issues' state at the end of the day.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *Contributors) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		issue := g.issues[evt.IssueKey]
+		issue.Segment = evt.Segment
+		switch evt.Kind {
+		case store.StatusChanged, store.IssueCreated:
+			issue.Status = evt.ValueTo
+		case store.AssigneeChanged:
+			issue.Assignee = evt.ValueTo
+		}
+		g.issues[evt.IssueKey] = issue
+	}
+
+	log.Printf("[metrics/contributors] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+func (g *Contributors) pushMetricsForDay(d time.Time, w store.MetricWriter) (int, error) {
+	wip := make(map[string]int)                       // segment -> WIP issues
+	wipPerAssignee := make(map[string]map[string]int) // segment -> assignee -> WIP issues
+	for _, issue := range g.issues {
+		if issue.Status != "wip" {
+			continue
+		}
+		wip[issue.Segment]++
+		if issue.Assignee != "" {
+			increment(wipPerAssignee, issue.Segment, issue.Assignee)
+		}
+	}
+
+	countMetrics := 0
+	for segment, assignees := range wipPerAssignee {
+		for assignee, value := range assignees {
+			err := w.WriteMetric(store.Metric{
+				Time:    d,
+				Name:    "contributors/wip",
+				Segment: segment,
+				Value:   float64(value),
+				Comment: assignee,
+			})
+			if err != nil {
+				return countMetrics, err
+			}
+			countMetrics++
+		}
+
+		err := w.WriteMetric(store.Metric{
+			Time:    d,
+			Name:    "contributors/wip_ratio",
+			Segment: segment,
+			Value:   float64(wip[segment]) / float64(len(assignees)),
+		})
+		if err != nil {
+			return countMetrics, err
+		}
+		countMetrics++
+	}
+	return countMetrics, nil
+}
+
+type contributorsState struct {
+	Clock  dayClock
+	Issues map[string]contributorIssue
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *Contributors) MarshalState() ([]byte, error) {
+	return json.Marshal(contributorsState{g.clock, g.issues})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *Contributors) UnmarshalState(data []byte) error {
+	var state contributorsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.issues = state.Clock, state.Issues
+	return nil
+}
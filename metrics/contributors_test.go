@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestContributors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "assigned and unassigned WIP issues",
+			events: []store.Event{
+				statusChange(at(0, 9), "A", "bug", "tribe_a", "backlog", "wip"),
+				assigneeChange(at(0, 10), "A", "bug", "tribe_a", "", "alice"),
+				statusChange(at(0, 11), "B", "bug", "tribe_a", "backlog", "wip"),
+				assigneeChange(at(0, 12), "B", "bug", "tribe_a", "", "bob"),
+				statusChange(at(0, 13), "C", "bug", "tribe_a", "backlog", "wip"),
+				assigneeChange(at(1, 9), "A", "bug", "tribe_a", "alice", "bob"),
+				statusChange(at(2, 9), "B", "bug", "tribe_a", "wip", "done"),
+			},
+			expected: []string{
+				"06-04T00 contributors/wip p/tribe_a 1 alice",
+				"06-04T00 contributors/wip p/tribe_a 1 bob",
+				"06-04T00 contributors/wip_ratio p/tribe_a 1.5",
+				"06-05T00 contributors/wip p/tribe_a 2 bob",
+				"06-05T00 contributors/wip_ratio p/tribe_a 3",
+			},
+		},
+		{
+			name: "assigned issues not in WIP",
+			events: []store.Event{
+				assigneeChange(at(0, 9), "A", "bug", "tribe_a", "", "alice"),
+				statusChange(at(0, 10), "B", "bug", "tribe_b", "backlog", "wip"),
+				assigneeChange(at(0, 11), "B", "bug", "tribe_b", "", "alice"),
+				statusChange(at(1, 9), "B", "bug", "tribe_b", "wip", "done"),
+			},
+			expected: []string{
+				"06-04T00 contributors/wip p/tribe_b 1 alice",
+				"06-04T00 contributors/wip_ratio p/tribe_b 1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewContributors(), tc.events), tc.expected)
+		})
+	}
+}
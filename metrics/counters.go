@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -18,7 +19,15 @@ var metrics = []string{
 // metric.
 type Counters struct {
 	counters map[string]map[string]int // name -> segment -> count
-	statuses map[string]string         // issue key -> previous status
+	issues   map[string]counterIssue   // issue key -> issue as counted
+}
+
+// counterIssue is the status, issue type and segment an issue is
+// counted with.
+type counterIssue struct {
+	Status    string
+	IssueType string
+	Segment   string
 }
 
 // NewCounters returns a `Counters` struct initialized with internal
@@ -31,7 +40,7 @@ func NewCounters() *Counters {
 
 	return &Counters{
 		counters,
-		make(map[string]string),
+		make(map[string]counterIssue),
 	}
 }
 
@@ -42,69 +51,115 @@ func NewCounters() *Counters {
 //   - WIP composition: WIP issues, split between product, bug, technical, ops --> name=wip_(product|bug|technical|ops)
 //   - Backlog composition: same as WIP composition, for backlog issues --> name=backlog_(product|bug|technical|ops)
 //
-// TODO: pass a parameter to enable mismatch logging
-func (g *Counters) Generate(events chan store.Event, segmentPrefix string, s *store.PGStore) {
+// Each issue is remembered with the status, issue type and segment it
+// is counted with, so it's uncounted from the right counters when it
+// changes status, issue type or segment.
+//
+// Inconsistencies in the events (e.g. a status change from a status
+// the issue was not in) are not reported here, see `Auditor`.
+func (g *Counters) Generate(events chan store.Event, w store.MetricWriter) error {
 	countMetrics := 0
 
 	for evt := range events {
-		loggingMismatches := false
-
-		statusWas := g.statuses[evt.IssueKey] // issue previous status
-		statusFrom, statusTo := evt.ValueFrom, evt.ValueTo
-		if loggingMismatches && statusWas != statusFrom {
-			log.Printf("**MISMATCH** was=%s from=%s for issue %s\n", statusWas, statusFrom, evt.IssueKey)
-			// TODO: add info in README/Troubleshooting to explain how to handle these messages
+		issue, known := g.issues[evt.IssueKey] // issue as previously counted
+
+		switch evt.Kind {
+		case store.StatusChanged, store.IssueCreated:
+			g.updateCounters(evt.IssueKey, issue, counterIssue{evt.ValueTo, evt.IssueType, evt.Segment})
+			// Using the previously counted status and not statusFrom to
+			// update counters because some status change histories in Jira
+			// may be redondant (e.g. you may have twice a change from "Open"
+			// to "In Development", maybe because of workflow changes).
+
+		case store.IssueTypeChanged, store.SegmentChanged:
+			if !known {
+				continue
+			}
+			g.updateCounters(evt.IssueKey, issue, counterIssue{issue.Status, evt.IssueType, evt.Segment})
+
+		default:
+			continue
 		}
 
-		// Updating issue's previous status
-		g.statuses[evt.IssueKey] = statusTo
-
-		g.updateCounters(statusWas, statusTo, evt.IssueType, evt.Segment)
-		// Using statusWas and not statusFrom to update counters because some
-		// status change histories in Jira may be redondant (e.g. you may
-		// have twice a change from "Open" to "In Development", maybe because
-		// of workflow changes).
-
-		countMetrics += g.pushMetrics(s, evt.Time, segmentPrefix)
+		n, err := g.pushMetrics(w, evt.Time)
+		countMetrics += n
+		if err != nil {
+			return err
+		}
 	}
 
 	log.Printf("[metrics/counters] pushed %d metrics\n",
 		countMetrics,
 	)
+	return nil
 }
 
-func (g *Counters) updateCounters(from, to, issueType, segment string) {
-	switch from {
-	case "backlog":
-		g.counters["cfd_backlog"][segment]--
-		g.counters[fmt.Sprintf("backlog_%s", issueType)][segment]--
-	case "wip":
-		g.counters["cfd_wip"][segment]--
-		g.counters[fmt.Sprintf("wip_%s", issueType)][segment]--
+// updateCounters uncounts the issue `key` as it was counted (`was`)
+// and counts it as `is`.
+func (g *Counters) updateCounters(key string, was, is counterIssue) {
+	g.count(was, -1)
+	g.count(is, 1)
+	g.issues[key] = is
+}
+
+// count adds `delta` to the counters of the issue's status and issue
+// type, for its segment.
+func (g *Counters) count(issue counterIssue, delta int) {
+	switch issue.Status {
+	case "backlog", "wip":
+		g.add(fmt.Sprintf("cfd_%s", issue.Status), issue.Segment, delta)
+		g.add(fmt.Sprintf("%s_%s", issue.Status, issue.IssueType), issue.Segment, delta)
 	}
+}
 
-	switch to {
-	case "backlog":
-		g.counters["cfd_backlog"][segment]++
-		g.counters[fmt.Sprintf("backlog_%s", issueType)][segment]++
-	case "wip":
-		g.counters["cfd_wip"][segment]++
-		g.counters[fmt.Sprintf("wip_%s", issueType)][segment]++
+// add adds `delta` to the counter `name` for `segment`. Counters for
+// issue type groups which are not in `metrics` (custom groups from the
+// configuration) are created when first used.
+func (g *Counters) add(name, segment string, delta int) {
+	if _, ok := g.counters[name]; !ok {
+		g.counters[name] = make(map[string]int)
 	}
+	g.counters[name][segment] += delta
 }
 
-func (g *Counters) pushMetrics(s *store.PGStore, t time.Time, segmentPrefix string) int {
+func (g *Counters) pushMetrics(w store.MetricWriter, t time.Time) (int, error) {
 	var countMetrics int
 	for metricName, segments := range g.counters {
 		for segment, value := range segments {
-			countMetrics++
-			s.WriteMetric(store.Metric{
+			err := w.WriteMetric(store.Metric{
 				Time:    t,
 				Name:    fmt.Sprintf("counter/%s", metricName),
-				Segment: fmt.Sprintf("%s/%s", segmentPrefix, segment),
+				Segment: segment,
 				Value:   float64(value),
 			})
+			if err != nil {
+				return countMetrics, err
+			}
+			countMetrics++
 		}
 	}
-	return countMetrics
+	return countMetrics, nil
+}
+
+type countersState struct {
+	Counters map[string]map[string]int
+	Issues   map[string]counterIssue
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *Counters) MarshalState() ([]byte, error) {
+	return json.Marshal(countersState{g.counters, g.issues})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *Counters) UnmarshalState(data []byte) error {
+	var state countersState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for name, segments := range state.Counters {
+		g.counters[name] = segments
+	}
+	g.issues = state.Issues
+	return nil
 }
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestCounters(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "redundant status transition",
+			events: []store.Event{
+				statusChange(at(0, 10), "A", "product", "tribe_a", "backlog", "wip"),
+				statusChange(at(0, 11), "A", "product", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 10), "A", "product", "tribe_a", "wip", "done"),
+			},
+			expected: []string{
+				"06-04T10 counter/cfd_wip p/tribe_a 1",
+				"06-04T10 counter/wip_product p/tribe_a 1",
+				"06-04T11 counter/cfd_wip p/tribe_a 1",
+				"06-04T11 counter/wip_product p/tribe_a 1",
+				"06-05T10 counter/cfd_wip p/tribe_a 0",
+				"06-05T10 counter/wip_product p/tribe_a 0",
+			},
+		},
+		{
+			name: "reopened issue",
+			events: []store.Event{
+				statusChange(at(0, 9), "B", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 9), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(2, 9), "B", "bug", "tribe_a", "done", "wip"),
+				statusChange(at(3, 9), "B", "bug", "tribe_a", "wip", "resolved"),
+			},
+			expected: []string{
+				"06-04T09 counter/cfd_wip p/tribe_a 1",
+				"06-04T09 counter/wip_bug p/tribe_a 1",
+				"06-05T09 counter/cfd_wip p/tribe_a 0",
+				"06-05T09 counter/wip_bug p/tribe_a 0",
+				"06-06T09 counter/cfd_wip p/tribe_a 1",
+				"06-06T09 counter/wip_bug p/tribe_a 1",
+				"06-07T09 counter/cfd_wip p/tribe_a 0",
+				"06-07T09 counter/wip_bug p/tribe_a 0",
+			},
+		},
+		{
+			name: "issue created and never transitioned",
+			events: []store.Event{
+				issueCreated(at(0, 9), "E", "bug", "tribe_a", "backlog"),
+				issueCreated(at(0, 10), "F", "bug", "tribe_a", "backlog"),
+				statusChange(at(0, 11), "F", "bug", "tribe_a", "backlog", "wip"),
+			},
+			expected: []string{
+				"06-04T09 counter/cfd_backlog p/tribe_a 1",
+				"06-04T09 counter/backlog_bug p/tribe_a 1",
+				"06-04T10 counter/cfd_backlog p/tribe_a 2",
+				"06-04T10 counter/backlog_bug p/tribe_a 2",
+				"06-04T11 counter/cfd_backlog p/tribe_a 1",
+				"06-04T11 counter/backlog_bug p/tribe_a 1",
+				"06-04T11 counter/cfd_wip p/tribe_a 1",
+				"06-04T11 counter/wip_bug p/tribe_a 1",
+			},
+		},
+		{
+			name: "issue type and segment changed",
+			events: []store.Event{
+				statusChange(at(0, 9), "G", "bug", "tribe_a", "backlog", "wip"),
+				issueTypeChange(at(0, 10), "G", "tribe_a", "bug", "product"),
+				segmentChange(at(0, 11), "G", "product", "tribe_a", "tribe_b"),
+				statusChange(at(0, 12), "G", "product", "tribe_b", "wip", "done"),
+			},
+			expected: []string{
+				"06-04T09 counter/cfd_wip p/tribe_a 1",
+				"06-04T09 counter/wip_bug p/tribe_a 1",
+				"06-04T10 counter/cfd_wip p/tribe_a 1",
+				"06-04T10 counter/wip_bug p/tribe_a 0",
+				"06-04T10 counter/wip_product p/tribe_a 1",
+				"06-04T11 counter/cfd_wip p/tribe_a 0",
+				"06-04T11 counter/cfd_wip p/tribe_b 1",
+				"06-04T11 counter/wip_bug p/tribe_a 0",
+				"06-04T11 counter/wip_product p/tribe_a 0",
+				"06-04T11 counter/wip_product p/tribe_b 1",
+				"06-04T12 counter/cfd_wip p/tribe_a 0",
+				"06-04T12 counter/cfd_wip p/tribe_b 0",
+				"06-04T12 counter/wip_bug p/tribe_a 0",
+				"06-04T12 counter/wip_product p/tribe_a 0",
+				"06-04T12 counter/wip_product p/tribe_b 0",
+			},
+		},
+		{
+			name: "issue type changed without a change event",
+			events: []store.Event{
+				statusChange(at(0, 9), "H", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(0, 10), "H", "product", "tribe_a", "wip", "backlog"),
+			},
+			expected: []string{
+				"06-04T09 counter/cfd_wip p/tribe_a 1",
+				"06-04T09 counter/wip_bug p/tribe_a 1",
+				"06-04T10 counter/cfd_backlog p/tribe_a 1",
+				"06-04T10 counter/backlog_product p/tribe_a 1",
+				"06-04T10 counter/cfd_wip p/tribe_a 0",
+				"06-04T10 counter/wip_bug p/tribe_a 0",
+			},
+		},
+		{
+			name: "backlog and wip in several segments",
+			events: []store.Event{
+				statusChange(at(0, 9), "C", "ops", "tribe_a", "wip", "backlog"),
+				statusChange(at(0, 10), "D", "technical", "tribe_b", "backlog", "wip"),
+			},
+			expected: []string{
+				"06-04T09 counter/cfd_backlog p/tribe_a 1",
+				"06-04T09 counter/backlog_ops p/tribe_a 1",
+				"06-04T10 counter/cfd_backlog p/tribe_a 1",
+				"06-04T10 counter/backlog_ops p/tribe_a 1",
+				"06-04T10 counter/cfd_wip p/tribe_b 1",
+				"06-04T10 counter/wip_technical p/tribe_b 1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewCounters(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+const oneDay = 24 * time.Hour
+
+// dayOf returns the start of the (UTC) day of `t`.
+func dayOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// weekOf returns the start of the week (Monday) of `t`.
+func weekOf(t time.Time) time.Time {
+	d := dayOf(t)
+	offset := (int(d.Weekday()) + 6) % 7 // days since Monday
+	return d.Add(-time.Duration(offset) * oneDay)
+}
+
+// dayClock tracks the day being processed by a generator
+// receiving events ordered by time ascending.
+//
+// Its field is exported so it can be saved in the generator's
+// state.
+type dayClock struct {
+	Current time.Time // the day of the last processed event
+}
+
+// advance moves the clock to the day of `t`, calling `endOfDay`
+// for each day which ended since the current day (days without
+// events included).
+//
+// Returns an error if `t` is before the current day.
+func (c *dayClock) advance(t time.Time, endOfDay func(day time.Time) error) error {
+	d := dayOf(t)
+	if c.Current.IsZero() {
+		c.Current = d
+		return nil
+	}
+	if d.Before(c.Current) {
+		return fmt.Errorf("received an event that happened before the day being processed (%s): events should be ordered by time ascending", t.Format(time.RFC3339))
+	}
+	for c.Current.Before(d) {
+		if err := endOfDay(c.Current); err != nil {
+			return err
+		}
+		c.Current = c.Current.Add(oneDay)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// FlowEfficiency implements `Generator` for the _Flow Efficiency_
+// metric.
+type FlowEfficiency struct {
+	clock  dayClock
+	issues map[string]flowIssue   // issue key -> issue
+	done   map[string][]flowCycle // segment -> cycles done in the last days
+}
+
+// flowIssue tracks the active time of an issue during its cycle.
+type flowIssue struct {
+	Status     string    // current status group
+	Waiting    bool      // true if the current status is a waiting status
+	Since      time.Time // time the issue entered the current status
+	CycleStart time.Time // time the issue entered `wip` for the first time
+	Active     float64   // days spent in active `wip` statuses
+}
+
+// flowCycle is the active and total time of an issue's cycle, done at
+// `Time`.
+type flowCycle struct {
+	Time   time.Time
+	Active float64
+	Total  float64
+}
+
+// NewFlowEfficiency returns a `FlowEfficiency` struct initialized
+// with internal data.
+func NewFlowEfficiency() *FlowEfficiency {
+	return &FlowEfficiency{
+		issues: make(map[string]flowIssue),
+		done:   make(map[string][]flowCycle),
+	}
+}
+
+// Generate generates the flow efficiency metrics: the time spent in
+// active `wip` statuses (i.e. not waiting statuses, see
+// `config.Config.IsWaiting`) divided by the cycle time.
+//
+// Generated metrics, per segment:
+//   - When an issue is done, its flow efficiency
+//     --> name=flow_efficiency, comment=<issue key>
+//   - Every day, the flow efficiency of the issues done in the last 30,
+//     60 and 90 days (total active time divided by total cycle time)
+//     --> name=flow_efficiency/rolling_<window>d, time=day
+//
+// As for the cycle time, the cycle starts when the issue enters `wip`
+// for the first time.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *FlowEfficiency) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged && evt.Kind != store.StatusChangedInGroup && evt.Kind != store.IssueCreated {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		issue := g.issues[evt.IssueKey]
+		if issue.Status == "wip" && !issue.Waiting {
+			issue.Active += float64(evt.Time.Sub(issue.Since)) / float64(oneDay)
+		}
+		issue.Status, issue.Waiting, issue.Since = evt.ValueTo, evt.Waiting, evt.Time
+		if evt.ValueTo == "wip" && issue.CycleStart.IsZero() {
+			issue.CycleStart = evt.Time
+		}
+		g.issues[evt.IssueKey] = issue
+
+		if evt.Kind != store.StatusChanged || evt.ValueTo != "done" || issue.CycleStart.IsZero() {
+			continue
+		}
+		total := float64(evt.Time.Sub(issue.CycleStart)) / float64(oneDay)
+		if total == 0 {
+			continue
+		}
+		err = w.WriteMetric(store.Metric{
+			Time:    evt.Time,
+			Name:    "flow_efficiency",
+			Segment: evt.Segment,
+			Value:   issue.Active / total,
+			Comment: evt.IssueKey,
+		})
+		if err != nil {
+			return err
+		}
+		countMetrics++
+		g.done[evt.Segment] = append(g.done[evt.Segment], flowCycle{evt.Time, issue.Active, total})
+	}
+
+	log.Printf("[metrics/flow_efficiency] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushMetricsForDay pushes the rolling flow efficiencies for the day
+// `d` which just ended, and forgets the cycles which are out of all
+// windows. Returns the number of metrics pushed.
+func (g *FlowEfficiency) pushMetricsForDay(d time.Time, w store.MetricWriter) (int, error) {
+	countMetrics := 0
+	windowEnd := d.Add(oneDay)
+	maxWindow := 0
+
+	for _, window := range rollingWindows {
+		if window > maxWindow {
+			maxWindow = window
+		}
+		windowStart := windowEnd.Add(-time.Duration(window) * oneDay)
+		for segment, cycles := range g.done {
+			var active, total float64
+			for _, c := range cycles {
+				if !c.Time.Before(windowStart) {
+					active += c.Active
+					total += c.Total
+				}
+			}
+			if total == 0 {
+				continue
+			}
+			err := w.WriteMetric(store.Metric{
+				Time:    d,
+				Name:    fmt.Sprintf("flow_efficiency/rolling_%dd", window),
+				Segment: segment,
+				Value:   active / total,
+			})
+			if err != nil {
+				return countMetrics, err
+			}
+			countMetrics++
+		}
+	}
+
+	// The next window starts one day later
+	oldest := windowEnd.Add(-time.Duration(maxWindow-1) * oneDay)
+	for segment, cycles := range g.done {
+		kept := make([]flowCycle, 0, len(cycles))
+		for _, c := range cycles {
+			if !c.Time.Before(oldest) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(g.done, segment)
+			continue
+		}
+		g.done[segment] = kept
+	}
+	return countMetrics, nil
+}
+
+type flowEfficiencyState struct {
+	Clock  dayClock
+	Issues map[string]flowIssue
+	Done   map[string][]flowCycle
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *FlowEfficiency) MarshalState() ([]byte, error) {
+	return json.Marshal(flowEfficiencyState{g.clock, g.issues, g.done})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *FlowEfficiency) UnmarshalState(data []byte) error {
+	var state flowEfficiencyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.issues, g.done = state.Clock, state.Issues, state.Done
+	return nil
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestFlowEfficiency(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "waiting and active statuses",
+			events: []store.Event{
+				statusChange(at(0, 0), "A", "bug", "tribe_a", "backlog", "wip"),
+				waiting(withStatuses(statusChange(at(1, 0), "A", "bug", "tribe_a", "wip", "wip"), "in_development", "pending")),
+				withStatuses(statusChange(at(3, 0), "A", "bug", "tribe_a", "wip", "wip"), "pending", "in_development"),
+				statusChange(at(4, 0), "A", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(4, 0), "B", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(6, 0), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(7, 0), "C", "bug", "tribe_a", "backlog", "wip"),
+			},
+			expected: []string{
+				"06-08T00 flow_efficiency p/tribe_a 0.5 A",
+				"06-10T00 flow_efficiency p/tribe_a 1 B",
+				"06-08T00 flow_efficiency/rolling_30d p/tribe_a 0.5",
+				"06-08T00 flow_efficiency/rolling_60d p/tribe_a 0.5",
+				"06-08T00 flow_efficiency/rolling_90d p/tribe_a 0.5",
+				"06-09T00 flow_efficiency/rolling_30d p/tribe_a 0.5",
+				"06-09T00 flow_efficiency/rolling_60d p/tribe_a 0.5",
+				"06-09T00 flow_efficiency/rolling_90d p/tribe_a 0.5",
+				"06-10T00 flow_efficiency/rolling_30d p/tribe_a 0.6666666666666666",
+				"06-10T00 flow_efficiency/rolling_60d p/tribe_a 0.6666666666666666",
+				"06-10T00 flow_efficiency/rolling_90d p/tribe_a 0.6666666666666666",
+			},
+		},
+		{
+			name: "reopened issue",
+			events: []store.Event{
+				statusChange(at(0, 0), "A", "ops", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "A", "ops", "tribe_a", "wip", "backlog"),
+				statusChange(at(3, 0), "A", "ops", "tribe_a", "backlog", "wip"),
+				statusChange(at(4, 0), "A", "ops", "tribe_a", "wip", "done"),
+			},
+			expected: []string{
+				"06-08T00 flow_efficiency p/tribe_a 0.5 A",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewFlowEfficiency(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Percentiles of the simulations reported in forecasts, and number
+// of days of throughput history the simulations are based on.
+var (
+	forecastPercentiles = []int{50, 85, 95}
+	forecastHistoryDays = 90
+)
+
+// Forecaster forecasts the completion date of the issues currently
+// in the backlog of each segment, using Monte Carlo simulations based
+// on the segment's historical daily throughput.
+//
+// It is not a `Generator`: forecasts are computed once all events have
+// been processed.
+type Forecaster struct {
+	runs       int
+	rand       *rand.Rand
+	issues     map[string]forecastIssue     // issue key -> issue
+	done       map[string]map[time.Time]int // segment -> day -> number of issues done
+	firstEvent time.Time
+	lastEvent  time.Time
+}
+
+type forecastIssue struct {
+	status  string
+	segment string
+}
+
+// NewForecaster returns a `Forecaster` running `runs` simulations per
+// segment (at least 1), using `rnd` to sample the throughput history.
+func NewForecaster(runs int, rnd *rand.Rand) *Forecaster {
+	return &Forecaster{
+		runs:   runs,
+		rand:   rnd,
+		issues: make(map[string]forecastIssue),
+		done:   make(map[string]map[time.Time]int),
+	}
+}
+
+// Forecast processes the `events` and returns the forecasts for each
+// segment with issues in backlog.
+//
+// Each simulation picks, for every day from the day of the last event,
+// the throughput of a random day of the `forecastHistoryDays` days
+// before (issues reaching `done`), until the backlog is completed. The
+// completion dates of the simulations are reported for each of
+// `forecastPercentiles`.
+//
+// Segments without any issue done in the history are not forecasted.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (f *Forecaster) Forecast(events chan store.Event) []store.Forecast {
+	for evt := range events {
+		if f.firstEvent.IsZero() {
+			f.firstEvent = evt.Time
+		}
+		f.lastEvent = evt.Time
+		if evt.Kind != store.StatusChanged && evt.Kind != store.IssueCreated {
+			continue
+		}
+		f.issues[evt.IssueKey] = forecastIssue{evt.ValueTo, evt.Segment}
+		if evt.Kind == store.StatusChanged && evt.ValueTo == "done" {
+			if _, ok := f.done[evt.Segment]; !ok {
+				f.done[evt.Segment] = make(map[time.Time]int)
+			}
+			f.done[evt.Segment][dayOf(evt.Time)]++
+		}
+	}
+
+	backlogs := make(map[string]int) // segment -> number of issues in backlog
+	for _, issue := range f.issues {
+		if issue.status == "backlog" {
+			backlogs[issue.segment]++
+		}
+	}
+
+	// The history ends with the last complete day, forecasts start
+	// from the day of the last event.
+	historyEnd := dayOf(f.lastEvent)
+	historyStart := historyEnd.Add(-time.Duration(forecastHistoryDays) * oneDay)
+	if first := dayOf(f.firstEvent); first.After(historyStart) {
+		historyStart = first
+	}
+
+	forecasts := make([]store.Forecast, 0)
+	for segment, backlog := range backlogs {
+		history := make([]int, 0, forecastHistoryDays)
+		total := 0
+		for d := historyStart; d.Before(historyEnd); d = d.Add(oneDay) {
+			history = append(history, f.done[segment][d])
+			total += f.done[segment][d]
+		}
+		if total == 0 {
+			log.Printf("[metrics/forecast] no throughput history for %s, not forecasted\n", segment)
+			continue
+		}
+
+		days := f.simulate(backlog, history)
+		for _, p := range forecastPercentiles {
+			forecasts = append(forecasts, store.Forecast{
+				Time:           historyEnd,
+				Segment:        segment,
+				BacklogSize:    backlog,
+				Percentile:     p,
+				CompletionDate: historyEnd.Add(time.Duration(percentile(days, p)-1) * oneDay),
+			})
+		}
+	}
+
+	log.Printf("[metrics/forecast] %d forecasts (%d runs per segment)\n",
+		len(forecasts),
+		f.runs,
+	)
+	return forecasts
+}
+
+// simulate runs the simulations for a backlog of `backlog` issues
+// and returns the number of days each one took to complete it, sorted
+// ascending. `history` must contain at least one non-zero value.
+func (f *Forecaster) simulate(backlog int, history []int) []float64 {
+	days := make([]float64, f.runs)
+	for i := range days {
+		remaining := backlog
+		for remaining > 0 {
+			remaining -= history[f.rand.Intn(len(history))]
+			days[i]++
+		}
+	}
+	sort.Float64s(days)
+	return days
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestForecaster(t *testing.T) {
+	events := []store.Event{
+		statusChange(at(0, 9), "A", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(0, 10), "B", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(1, 9), "C", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(1, 10), "D", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(2, 9), "E", "bug", "tribe_a", "wip", "backlog"),
+		statusChange(at(2, 9), "F", "bug", "tribe_a", "wip", "backlog"),
+		statusChange(at(2, 9), "G", "bug", "tribe_a", "wip", "backlog"),
+		statusChange(at(2, 9), "H", "bug", "tribe_b", "wip", "backlog"),
+	}
+	eventsChan := make(chan store.Event, len(events))
+	for _, evt := range events {
+		eventsChan <- evt
+	}
+	close(eventsChan)
+
+	f := NewForecaster(100, rand.New(rand.NewSource(1)))
+	forecasts := f.Forecast(eventsChan)
+
+	// tribe_a: 2 issues done every day, 3 in backlog -> 2 days.
+	// tribe_b: no history, not forecasted.
+	if len(forecasts) != len(forecastPercentiles) {
+		t.Fatalf("expected %d forecasts, got %d", len(forecastPercentiles), len(forecasts))
+	}
+	for _, f := range forecasts {
+		if f.Segment != "p/tribe_a" || f.BacklogSize != 3 || !f.Time.Equal(at(2, 0)) || !f.CompletionDate.Equal(at(3, 0)) {
+			t.Errorf("unexpected forecast %+v", f)
+		}
+	}
+}
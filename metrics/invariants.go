@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// InvariantChecker implements `store.MetricWriter`, forwarding metrics
+// to another writer while checking identities between them, per
+// segment:
+//   - the composition counters add up to the CFD counters (e.g.
+//     `counter/wip_product + counter/wip_bug + ... == counter/cfd_wip`),
+//     checked for every time counters are pushed at
+//   - the issues age buckets add up to the CFD counters at the end of
+//     the day (e.g. the sum of `issuesAge/wip_*` for a day is the
+//     value of `counter/cfd_wip` at the end of the day)
+//
+// Violations are reported by `Check`, once all metrics are written.
+type InvariantChecker struct {
+	sync.Mutex
+	w store.MetricWriter
+
+	countersTime time.Time                               // time of the last counters pushed
+	counters     map[string]map[string]float64           // segment -> counter name -> last value
+	cfdEndOfDay  map[time.Time]map[string]map[string]int // day -> status -> segment -> CFD counter at the end of the day
+	issuesAge    map[time.Time]map[string]map[string]int // day -> status -> segment -> issues in age buckets
+	violations   []string
+}
+
+// NewInvariantChecker returns an `InvariantChecker` writing metrics
+// to `w`.
+func NewInvariantChecker(w store.MetricWriter) *InvariantChecker {
+	return &InvariantChecker{
+		w:           w,
+		counters:    make(map[string]map[string]float64),
+		cfdEndOfDay: make(map[time.Time]map[string]map[string]int),
+		issuesAge:   make(map[time.Time]map[string]map[string]int),
+	}
+}
+
+// WriteMetric records the metric for the checks and writes it to the
+// underlying writer.
+func (c *InvariantChecker) WriteMetric(metric store.Metric) error {
+	c.Lock()
+	switch {
+	case strings.HasPrefix(metric.Name, "counter/"):
+		if metric.Time.After(c.countersTime) {
+			c.counted(metric.Time)
+		}
+		if _, ok := c.counters[metric.Segment]; !ok {
+			c.counters[metric.Segment] = make(map[string]float64)
+		}
+		c.counters[metric.Segment][strings.TrimPrefix(metric.Name, "counter/")] = metric.Value
+
+	case strings.HasPrefix(metric.Name, "issuesAge/"):
+		// Only the buckets per segment, not per issue type
+		bucket := strings.TrimPrefix(metric.Name, "issuesAge/")
+		if strings.Contains(bucket, "/") {
+			break
+		}
+		for _, status := range []string{"backlog", "wip"} {
+			if strings.HasPrefix(bucket, status+"_") {
+				add(c.issuesAge, metric.Time, status, metric.Segment, int(metric.Value))
+			}
+		}
+	}
+	c.Unlock()
+
+	return c.w.WriteMetric(metric)
+}
+
+// counted is called when counters are pushed at `t`, after the
+// counters pushed at `c.countersTime`: checks the last counters and
+// keeps the CFD counters of the days which ended.
+func (c *InvariantChecker) counted(t time.Time) {
+	if c.countersTime.IsZero() {
+		c.countersTime = t
+		return
+	}
+	c.checkCounters()
+	for d := dayOf(c.countersTime); d.Before(dayOf(t)); d = d.Add(oneDay) {
+		c.keepEndOfDay(d)
+	}
+	c.countersTime = t
+}
+
+// checkCounters checks the composition counters of each status add up
+// to its CFD counter.
+func (c *InvariantChecker) checkCounters() {
+	for segment, counters := range c.counters {
+		for _, status := range []string{"backlog", "wip"} {
+			var sum float64
+			for name, value := range counters {
+				if strings.HasPrefix(name, status+"_") {
+					sum += value
+				}
+			}
+			if cfd := counters["cfd_"+status]; sum != cfd {
+				c.violation(c.countersTime, segment, "sum of `counter/%s_*` is %g but `counter/cfd_%s` is %g", status, sum, status, cfd)
+			}
+		}
+	}
+}
+
+func (c *InvariantChecker) keepEndOfDay(d time.Time) {
+	for segment, counters := range c.counters {
+		for _, status := range []string{"backlog", "wip"} {
+			add(c.cfdEndOfDay, d, status, segment, int(counters["cfd_"+status]))
+		}
+	}
+}
+
+// Check completes the checks once all metrics have been written,
+// logs the violations and returns an error if there are any.
+func (c *InvariantChecker) Check() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.countersTime.IsZero() {
+		c.checkCounters()
+		c.keepEndOfDay(dayOf(c.countersTime))
+	}
+
+	// Comparing the days both counters and issues age were pushed for
+	for d, statuses := range c.issuesAge {
+		cfd, ok := c.cfdEndOfDay[d]
+		if !ok {
+			continue
+		}
+		for _, status := range []string{"backlog", "wip"} {
+			segments := make(map[string]bool)
+			for segment := range statuses[status] {
+				segments[segment] = true
+			}
+			for segment := range cfd[status] {
+				segments[segment] = true
+			}
+			for segment := range segments {
+				if age, count := statuses[status][segment], cfd[status][segment]; age != count {
+					c.violation(d, segment, "sum of `issuesAge/%s_*` is %d but `counter/cfd_%s` is %d at the end of the day", status, age, status, count)
+				}
+			}
+		}
+	}
+
+	if len(c.violations) == 0 {
+		log.Printf("[metrics/invariants] no violation\n")
+		return nil
+	}
+	sort.Strings(c.violations)
+	for _, v := range c.violations {
+		log.Printf("[metrics/invariants] %s\n", v)
+	}
+	return fmt.Errorf("%d invariant violations", len(c.violations))
+}
+
+func (c *InvariantChecker) violation(t time.Time, segment, format string, args ...interface{}) {
+	c.violations = append(c.violations, fmt.Sprintf("%s %s: %s", t.Format(time.RFC3339), segment, fmt.Sprintf(format, args...)))
+}
+
+// add adds `value` to the count for `day`, `status` and `segment` in
+// `counts`.
+func add(counts map[time.Time]map[string]map[string]int, day time.Time, status, segment string, value int) {
+	if _, ok := counts[day]; !ok {
+		counts[day] = make(map[string]map[string]int)
+	}
+	if _, ok := counts[day][status]; !ok {
+		counts[day][status] = make(map[string]int)
+	}
+	counts[day][status][segment] += value
+}
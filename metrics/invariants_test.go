@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestInvariantChecker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "consistent metrics",
+			events: []store.Event{
+				issueCreated(at(0, 9), "A", "bug", "tribe_a", "backlog"),
+				statusChange(at(0, 10), "A", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(0, 11), "B", "product", "tribe_b", "backlog", "wip"),
+				segmentChange(at(1, 10), "B", "product", "tribe_b", "tribe_a"),
+				statusChange(at(3, 10), "A", "bug", "tribe_a", "wip", "done"),
+			},
+		},
+		{
+			name: "status mismatch",
+			events: []store.Event{
+				statusChange(at(0, 10), "A", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 10), "A", "bug", "tribe_a", "backlog", "done"),
+				statusChange(at(2, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"2018-06-05T00:00:00Z p/tribe_a: sum of `issuesAge/wip_*` is 1 but `counter/cfd_wip` is 0 at the end of the day",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := store.NewMemStore(tc.events)
+			c := NewInvariantChecker(s)
+			for _, g := range []Generator{NewCounters(), newIssuesAge()} {
+				events := make(chan store.Event, 0)
+				go s.StreamEvents(context.Background(), time.Time{}, events)
+				if err := g.Generate(events, c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := c.Check()
+			if len(tc.expected) == 0 && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if len(tc.expected) > 0 && err == nil {
+				t.Errorf("expected an error")
+			}
+			if actual, expected := strings.Join(c.violations, "\n"), strings.Join(tc.expected, "\n"); actual != expected {
+				t.Errorf("unexpected violations\nexpected:\n  %s\nactual:\n  %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestInvariantCheckerCounters(t *testing.T) {
+	c := NewInvariantChecker(store.NewMemStore(nil))
+	for _, m := range []store.Metric{
+		{Time: at(0, 10), Name: "counter/cfd_wip", Segment: "p/tribe_a", Value: 2},
+		{Time: at(0, 10), Name: "counter/wip_bug", Segment: "p/tribe_a", Value: 1},
+		{Time: at(0, 10), Name: "counter/cfd_backlog", Segment: "p/tribe_a", Value: 0},
+		{Time: at(0, 11), Name: "counter/cfd_wip", Segment: "p/tribe_a", Value: 1},
+		{Time: at(0, 11), Name: "counter/wip_bug", Segment: "p/tribe_a", Value: 1},
+	} {
+		if err := c.WriteMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Check(); err == nil {
+		t.Errorf("expected an error")
+	}
+	expected := "2018-06-04T10:00:00Z p/tribe_a: sum of `counter/wip_*` is 1 but `counter/cfd_wip` is 2"
+	if actual := strings.Join(c.violations, "\n"); actual != expected {
+		t.Errorf("unexpected violations\nexpected:\n  %s\nactual:\n  %s", expected, actual)
+	}
+}
@@ -1,31 +1,27 @@
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/rchampourlier/kaizenizer/config"
 	"github.com/rchampourlier/kaizenizer/store"
 )
 
-type ageBucket struct {
-	name   string
-	maxAge time.Duration
-}
-
 const maxInt = int64(^uint64(0) >> 1)
 
-// NB: the ageBuckets slice must be kept ordered by max age ascending
-var ageBuckets = []ageBucket{
-	ageBucket{"1d", 24 * time.Hour},
-	ageBucket{"1w", 7 * 24 * time.Hour},
-	ageBucket{"1m", 730 * time.Hour}, // 365,25d / 12m --> 30,4d * 24h
-	ageBucket{"more", time.Duration(maxInt)},
-}
+// moreBucket is the last age bucket, counting the issues older than
+// all configured buckets.
+var moreBucket = config.AgeBucket{Label: "more", MaxAge: time.Duration(maxInt)}
 
 // IssuesAge implements `Generator` for the _IssuesAge_ metric.
 type IssuesAge struct {
-	currentDay    time.Time           // the day of the last processed event
+	backlogBuckets []config.AgeBucket // ordered by max age ascending
+	wipBuckets     []config.AgeBucket // ordered by max age ascending
+
+	clock         dayClock
 	backlogIssues map[string]issueAge // issue key -> issue struct
 	wipIssues     map[string]issueAge // issue key -> issue struct
 }
@@ -37,57 +33,64 @@ type issueAge struct {
 }
 
 // NewIssuesAge returns a `IssuesAge` struct initialized with internal
-// data.
-func NewIssuesAge() *IssuesAge {
+// data, counting backlog and WIP issues in the specified age buckets
+// (ordered by max age ascending) and a last `more` bucket.
+func NewIssuesAge(backlogBuckets, wipBuckets []config.AgeBucket) *IssuesAge {
 	return &IssuesAge{
-		backlogIssues: make(map[string]issueAge),
-		wipIssues:     make(map[string]issueAge),
+		backlogBuckets: append(append([]config.AgeBucket{}, backlogBuckets...), moreBucket),
+		wipBuckets:     append(append([]config.AgeBucket{}, wipBuckets...), moreBucket),
+		backlogIssues:  make(map[string]issueAge),
+		wipIssues:      make(map[string]issueAge),
 	}
 }
 
-// Generate generates metrics on issues age.
+// Generate generates metrics on issues age: at the end of every day,
+// the number of backlog and WIP issues in each age bucket, per segment
+// (name=issuesAge/(backlog|wip)_<bucket label>, e.g. `issuesAge/wip_1w`)
+// and per segment and issue type (e.g. `issuesAge/wip_1w/bug`, only
+// pushed when not 0). Issues are counted with their current issue
+// type and segment. The last event's day is pushed by the next run,
+// once it ended.
 //
 // NB: `events` must be sent in *ascending order on time*.
-func (g *IssuesAge) Generate(events chan store.Event, segmentPrefix string, s *store.PGStore) {
+func (g *IssuesAge) Generate(events chan store.Event, w store.MetricWriter) error {
 	countMetrics := 0
 
 	for evt := range events {
-		//log.Printf("processing %s\n", evt)
-		var emptyTime time.Time
-		evtDay := time.Date(evt.Time.Year(), evt.Time.Month(), evt.Time.Day(), 0, 0, 0, 0, time.UTC)
-
-		if emptyTime.Equal(g.currentDay) {
-			// currentDay not set
-			g.currentDay = evtDay
-			//log.Printf("setting current day to %s\n", evtDay)
-			g.updateIssuesLists(evt)
-
-		} else if evtDay.Equal(g.currentDay) {
-			// event still in current day
-			g.updateIssuesLists(evt)
-
-		} else if evtDay.After(g.currentDay) {
-			// event in a day after current day
-			for d := g.currentDay; d.Before(evtDay); d = d.Add(24 * time.Hour) {
-				countMetrics += g.calculateAndPushMetricsForDay(d, s, segmentPrefix)
-			}
-			g.currentDay = evtDay
-			g.updateIssuesLists(evt)
-
-		} else {
-			// event before current day --> ERROR
-			log.Fatalf("received an event that happened before the day being processed: events should be ordered by time ascending!")
+		switch evt.Kind {
+		case store.StatusChanged, store.IssueCreated, store.IssueTypeChanged, store.SegmentChanged:
+		default:
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.calculateAndPushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
 		}
+		g.updateIssuesLists(evt)
 	}
-	// After the last event, calculate and push counters for the current day
-	countMetrics += g.calculateAndPushMetricsForDay(g.currentDay, s, segmentPrefix)
 
 	log.Printf("[metrics/issues_age] pushed %d metrics\n",
 		countMetrics,
 	)
+	return nil
 }
 
 func (g *IssuesAge) updateIssuesLists(evt store.Event) {
+	if evt.Kind == store.IssueTypeChanged || evt.Kind == store.SegmentChanged {
+		// The issue stays in its list, with its age, but is now
+		// counted for its new issue type or segment.
+		for _, issues := range []map[string]issueAge{g.backlogIssues, g.wipIssues} {
+			if issue, ok := issues[evt.IssueKey]; ok {
+				issue.issueType, issue.segment = evt.IssueType, evt.Segment
+				issues[evt.IssueKey] = issue
+			}
+		}
+		return
+	}
 	switch evt.ValueFrom {
 	case "backlog":
 		delete(g.backlogIssues, evt.IssueKey)
@@ -110,20 +113,25 @@ func (g *IssuesAge) updateIssuesLists(evt store.Event) {
 	}
 }
 
-// calculateAndPushMetricsForDay returns the number of metrics pushed.
-func (g *IssuesAge) calculateAndPushMetricsForDay(day time.Time, s *store.PGStore, segmentPrefix string) int {
+// calculateAndPushMetricsForDay pushes the metrics for `day`, once
+// it ended, and returns the number of metrics pushed.
+func (g *IssuesAge) calculateAndPushMetricsForDay(day time.Time, w store.MetricWriter) (int, error) {
 	countMetrics := 0
 	counters := make(map[string]map[string]int)
-	for _, ageBucket := range ageBuckets {
-		counters[fmt.Sprintf("backlog_%s", ageBucket.name)] = make(map[string]int)
-		counters[fmt.Sprintf("wip_%s", ageBucket.name)] = make(map[string]int)
+	for _, ageBucket := range g.backlogBuckets {
+		counters[fmt.Sprintf("backlog_%s", ageBucket.Label)] = make(map[string]int)
+	}
+	for _, ageBucket := range g.wipBuckets {
+		counters[fmt.Sprintf("wip_%s", ageBucket.Label)] = make(map[string]int)
 	}
 
 	for _, issue := range g.backlogIssues {
 		issueAge := day.Sub(issue.start)
-		for _, ageBucket := range ageBuckets {
-			if issueAge < ageBucket.maxAge {
-				counters[fmt.Sprintf("backlog_%s", ageBucket.name)][issue.segment]++
+		for _, ageBucket := range g.backlogBuckets {
+			if issueAge < ageBucket.MaxAge {
+				key := fmt.Sprintf("backlog_%s", ageBucket.Label)
+				counters[key][issue.segment]++
+				increment(counters, fmt.Sprintf("%s/%s", key, issue.issueType), issue.segment)
 				break
 				// Out of the ageBuckets loop.
 				// It's ok because we iterate over ageBuckets by ascending max age
@@ -132,9 +140,11 @@ func (g *IssuesAge) calculateAndPushMetricsForDay(day time.Time, s *store.PGStor
 	}
 	for _, issue := range g.wipIssues {
 		issueAge := day.Sub(issue.start)
-		for _, ageBucket := range ageBuckets {
-			if issueAge < ageBucket.maxAge {
-				counters[fmt.Sprintf("wip_%s", ageBucket.name)][issue.segment]++
+		for _, ageBucket := range g.wipBuckets {
+			if issueAge < ageBucket.MaxAge {
+				key := fmt.Sprintf("wip_%s", ageBucket.Label)
+				counters[key][issue.segment]++
+				increment(counters, fmt.Sprintf("%s/%s", key, issue.issueType), issue.segment)
 				break
 				// Out of the ageBuckets loop.
 				// It's ok because we iterate over ageBuckets by ascending max age
@@ -143,15 +153,60 @@ func (g *IssuesAge) calculateAndPushMetricsForDay(day time.Time, s *store.PGStor
 	}
 	for ageBucket, counters := range counters {
 		for segment, value := range counters {
-			countMetrics++
 			metric := store.Metric{
 				Time:    day,
 				Name:    fmt.Sprintf("issuesAge/%s", ageBucket),
-				Segment: fmt.Sprintf("%s/%s", segmentPrefix, segment),
+				Segment: segment,
 				Value:   float64(value),
 			}
-			s.WriteMetric(metric)
+			if err := w.WriteMetric(metric); err != nil {
+				return countMetrics, err
+			}
+			countMetrics++
 		}
 	}
-	return countMetrics
+	return countMetrics, nil
+}
+
+type issuesAgeState struct {
+	Clock         dayClock
+	BacklogIssues map[string]issueAge
+	WIPIssues     map[string]issueAge
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *IssuesAge) MarshalState() ([]byte, error) {
+	return json.Marshal(issuesAgeState{g.clock, g.backlogIssues, g.wipIssues})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *IssuesAge) UnmarshalState(data []byte) error {
+	var state issuesAgeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock = state.Clock
+	g.backlogIssues, g.wipIssues = state.BacklogIssues, state.WIPIssues
+	return nil
+}
+
+type issueAgeJSON struct {
+	Start     time.Time
+	IssueType string
+	Segment   string
+}
+
+// MarshalJSON implements `json.Marshaler`.
+func (i issueAge) MarshalJSON() ([]byte, error) {
+	return json.Marshal(issueAgeJSON{i.start, i.issueType, i.segment})
+}
+
+// UnmarshalJSON implements `json.Unmarshaler`.
+func (i *issueAge) UnmarshalJSON(data []byte) error {
+	var v issueAgeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	i.start, i.issueType, i.segment = v.Start, v.IssueType, v.Segment
+	return nil
 }
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/config"
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// newIssuesAge returns an `IssuesAge` generator with the default age
+// buckets.
+func newIssuesAge() *IssuesAge {
+	return newIssuesAgeWithBuckets(config.DefaultAgeBuckets, config.DefaultAgeBuckets)
+}
+
+func newIssuesAgeWithBuckets(backlogLabels, wipLabels []string) *IssuesAge {
+	backlog, err := config.ParseAgeBuckets(backlogLabels)
+	if err != nil {
+		panic(err)
+	}
+	wip, err := config.ParseAgeBuckets(wipLabels)
+	if err != nil {
+		panic(err)
+	}
+	return NewIssuesAge(backlog, wip)
+}
+
+func TestIssuesAge(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "backlog and wip issues",
+			events: []store.Event{
+				createdAt(statusChange(at(0, 10), "A", "product", "tribe_a", "wip", "backlog"), at(-10, 0)),
+				statusChange(at(0, 12), "B", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(3, 9), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(4, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-04T00 issuesAge/backlog_1m p/tribe_a 1",
+				"06-04T00 issuesAge/backlog_1m/product p/tribe_a 1",
+				"06-04T00 issuesAge/wip_1d p/tribe_a 1",
+				"06-04T00 issuesAge/wip_1d/bug p/tribe_a 1",
+				"06-05T00 issuesAge/backlog_1m p/tribe_a 1",
+				"06-05T00 issuesAge/backlog_1m/product p/tribe_a 1",
+				"06-05T00 issuesAge/wip_1d p/tribe_a 1",
+				"06-05T00 issuesAge/wip_1d/bug p/tribe_a 1",
+				"06-06T00 issuesAge/backlog_1m p/tribe_a 1",
+				"06-06T00 issuesAge/backlog_1m/product p/tribe_a 1",
+				"06-06T00 issuesAge/wip_1w p/tribe_a 1",
+				"06-06T00 issuesAge/wip_1w/bug p/tribe_a 1",
+				"06-07T00 issuesAge/backlog_1m p/tribe_a 1",
+				"06-07T00 issuesAge/backlog_1m/product p/tribe_a 1",
+			},
+		},
+		{
+			name: "issue created and never transitioned",
+			events: []store.Event{
+				issueCreated(at(0, 10), "F", "bug", "tribe_a", "backlog"),
+				issueCreated(at(1, 10), "G", "bug", "tribe_a", "wip"),
+				statusChange(at(2, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-04T00 issuesAge/backlog_1d p/tribe_a 1",
+				"06-04T00 issuesAge/backlog_1d/bug p/tribe_a 1",
+				"06-05T00 issuesAge/backlog_1d p/tribe_a 1",
+				"06-05T00 issuesAge/backlog_1d/bug p/tribe_a 1",
+				"06-05T00 issuesAge/wip_1d p/tribe_a 1",
+				"06-05T00 issuesAge/wip_1d/bug p/tribe_a 1",
+			},
+		},
+		{
+			name: "issue type and segment changed",
+			events: []store.Event{
+				statusChange(at(0, 12), "H", "bug", "tribe_a", "backlog", "wip"),
+				issueTypeChange(at(1, 9), "H", "tribe_a", "bug", "product"),
+				segmentChange(at(1, 10), "H", "product", "tribe_a", "tribe_b"),
+				statusChange(at(2, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-04T00 issuesAge/wip_1d p/tribe_a 1",
+				"06-04T00 issuesAge/wip_1d/bug p/tribe_a 1",
+				"06-05T00 issuesAge/wip_1d p/tribe_b 1",
+				"06-05T00 issuesAge/wip_1d/product p/tribe_b 1",
+			},
+		},
+		{
+			name: "old backlog issues in several segments",
+			events: []store.Event{
+				createdAt(statusChange(at(0, 10), "C", "ops", "tribe_a", "wip", "backlog"), at(-40, 0)),
+				createdAt(statusChange(at(0, 11), "D", "ops", "tribe_b", "wip", "backlog"), at(-3, 0)),
+				createdAt(statusChange(at(0, 12), "E", "ops", "tribe_b", "wip", "backlog"), at(-4, 0)),
+				statusChange(at(1, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-04T00 issuesAge/backlog_more p/tribe_a 1",
+				"06-04T00 issuesAge/backlog_more/ops p/tribe_a 1",
+				"06-04T00 issuesAge/backlog_1w p/tribe_b 2",
+				"06-04T00 issuesAge/backlog_1w/ops p/tribe_b 2",
+			},
+		},
+		{
+			name: "current day not pushed",
+			events: []store.Event{
+				statusChange(at(0, 10), "I", "bug", "tribe_a", "backlog", "wip"),
+			},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, newIssuesAge(), tc.events), tc.expected)
+		})
+	}
+}
+
+func TestIssuesAgeConfiguredBuckets(t *testing.T) {
+	events := []store.Event{
+		createdAt(statusChange(at(0, 10), "A", "product", "tribe_a", "wip", "backlog"), at(-2, 0)),
+		createdAt(statusChange(at(0, 11), "B", "product", "tribe_a", "wip", "backlog"), at(-20, 0)),
+		createdAt(statusChange(at(0, 12), "C", "product", "tribe_a", "wip", "backlog"), at(-200, 0)),
+		statusChange(at(0, 13), "D", "bug", "tribe_a", "backlog", "wip"),
+		statusChange(at(1, 0), "Z", "bug", "tribe_a", "done", "resolved"),
+	}
+	g := newIssuesAgeWithBuckets([]string{"3d", "2w", "1q"}, []string{"1w"})
+	assertMetrics(t, generate(t, g, events), []string{
+		"06-04T00 issuesAge/backlog_3d p/tribe_a 1",
+		"06-04T00 issuesAge/backlog_3d/product p/tribe_a 1",
+		"06-04T00 issuesAge/backlog_more p/tribe_a 1",
+		"06-04T00 issuesAge/backlog_more/product p/tribe_a 1",
+		"06-04T00 issuesAge/backlog_1q p/tribe_a 1",
+		"06-04T00 issuesAge/backlog_1q/product p/tribe_a 1",
+		"06-04T00 issuesAge/wip_1w p/tribe_a 1",
+		"06-04T00 issuesAge/wip_1w/bug p/tribe_a 1",
+	})
+}
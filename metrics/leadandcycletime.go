@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -18,6 +19,9 @@ type period struct {
 type LeadAndCycleTime struct {
 	cyclePeriods map[string]period // issue key -> cycle time period
 	leadPeriods  map[string]period // issue key -> lead time period
+	segments     map[string]string // issue key -> segment
+	issueTypes   map[string]string // issue key -> issue type
+	lastEvent    time.Time         // time of the last processed event
 }
 
 // NewLeadAndCycleTime returns an initialized LeadAndCycleTime struct.
@@ -25,22 +29,49 @@ func NewLeadAndCycleTime() *LeadAndCycleTime {
 	return &LeadAndCycleTime{
 		make(map[string]period),
 		make(map[string]period),
+		make(map[string]string),
+		make(map[string]string),
+		time.Time{},
 	}
 }
 
-// Generate generates the Lead Time metrics.
-func (g *LeadAndCycleTime) Generate(events chan store.Event, segmentPrefix string, s *store.PGStore) {
+// Generate generates the Lead Time metrics. Metrics are segmented
+// per segment and issue type (e.g. `jobteaser/tribe_x/bug`), using
+// the issue's segment and issue type at its last event.
+//
+// Once all events are processed, it also generates, for every day
+// which ended (i.e. before the last event's day), the rolling
+// percentiles of lead and cycle times (e.g. `cycle_time/p85_30d`, see
+// `pushRollingPercentiles`), per segment and per segment and issue
+// type.
+//
+// When resuming from a checkpoint, metrics are only pushed for
+// periods ending after the checkpoint. An issue which was already
+// reported before the checkpoint and is completed again after it
+// gets a new data point.
+func (g *LeadAndCycleTime) Generate(events chan store.Event, w store.MetricWriter) error {
 	var countIssues, countMetrics int
+	since := g.lastEvent
 
 	for evt := range events {
+		g.lastEvent = evt.Time
+		if evt.Kind != store.StatusChanged && evt.Kind != store.IssueCreated {
+			continue
+		}
 		ik, to := evt.IssueKey, evt.ValueTo
 
 		if _, ok := g.cyclePeriods[ik]; !ok {
 			// First time the issue appears in an event
 			countIssues++
 			g.cyclePeriods[ik] = period{}
-			g.leadPeriods[ik] = period{startSet: true, start: evt.Time}
+			start := evt.Time
+			if evt.Kind == store.IssueCreated && !evt.IssueCreatedAt.IsZero() && evt.IssueCreatedAt.Before(start) {
+				// Sent after its creation (e.g. when resuming)
+				start = evt.IssueCreatedAt
+			}
+			g.leadPeriods[ik] = period{startSet: true, start: start}
 		}
+		g.segments[ik], g.issueTypes[ik] = evt.Segment, evt.IssueType
 
 		switch to {
 
@@ -73,29 +104,68 @@ func (g *LeadAndCycleTime) Generate(events chan store.Event, segmentPrefix strin
 		}
 	}
 
+	leadPoints := make(map[string][]dataPoint)  // segment -> lead times
+	cyclePoints := make(map[string][]dataPoint) // segment -> cycle times
 	for k := range g.cyclePeriods {
 		leadPeriod, cyclePeriod := g.leadPeriods[k], g.cyclePeriods[k]
+		segments := []string{
+			g.segments[k],
+			fmt.Sprintf("%s/%s", g.segments[k], g.issueTypes[k]),
+		}
 
 		if ok, dur := periodDurationInDays(leadPeriod); ok {
-			countMetrics++
-			s.WriteMetric(store.Metric{
+			for _, segment := range segments {
+				leadPoints[segment] = append(leadPoints[segment], dataPoint{leadPeriod.end, dur})
+			}
+		}
+		if ok, dur := periodDurationInDays(cyclePeriod); ok {
+			for _, segment := range segments {
+				cyclePoints[segment] = append(cyclePoints[segment], dataPoint{cyclePeriod.end, dur})
+			}
+		}
+
+		if ok, dur := periodDurationInDays(leadPeriod); ok && leadPeriod.end.After(since) {
+			err := w.WriteMetric(store.Metric{
 				Time:    leadPeriod.end,
 				Name:    "lead_time",
-				Segment: fmt.Sprintf("%s", segmentPrefix),
+				Segment: segments[1],
 				Value:   float64(dur),
 				Comment: k,
 			})
+			if err != nil {
+				return err
+			}
+			countMetrics++
 		}
 
-		if ok, dur := periodDurationInDays(cyclePeriod); ok {
-			countMetrics++
-			s.WriteMetric(store.Metric{
+		if ok, dur := periodDurationInDays(cyclePeriod); ok && cyclePeriod.end.After(since) {
+			err := w.WriteMetric(store.Metric{
 				Time:    cyclePeriod.end,
 				Name:    "cycle_time",
-				Segment: fmt.Sprintf("%s", segmentPrefix),
+				Segment: segments[1],
 				Value:   float64(dur),
 				Comment: k,
 			})
+			if err != nil {
+				return err
+			}
+			countMetrics++
+		}
+	}
+
+	// Percentiles are pushed for the days which ended, from the
+	// checkpoint's day (or the first data point's day). The last
+	// event's day is pushed by the next run, once it ended.
+	to := dayOf(g.lastEvent).Add(-oneDay)
+	for name, points := range map[string]map[string][]dataPoint{"lead_time": leadPoints, "cycle_time": cyclePoints} {
+		from := dayOf(since)
+		if since.IsZero() {
+			from = firstDay(points)
+		}
+		n, err := pushRollingPercentiles(w, name, points, from, to)
+		countMetrics += n
+		if err != nil {
+			return err
 		}
 	}
 
@@ -103,6 +173,21 @@ func (g *LeadAndCycleTime) Generate(events chan store.Event, segmentPrefix strin
 		countMetrics,
 		countIssues,
 	)
+	return nil
+}
+
+// firstDay returns the day of the earliest data point, or a zero
+// time if there is none.
+func firstDay(points map[string][]dataPoint) time.Time {
+	var first time.Time
+	for _, segmentPoints := range points {
+		for _, p := range segmentPoints {
+			if first.IsZero() || p.time.Before(first) {
+				first = p.time
+			}
+		}
+	}
+	return dayOf(first)
 }
 
 // Returns (true, <duration>) if period has both start and
@@ -113,3 +198,47 @@ func periodDurationInDays(p period) (bool, float64) {
 	}
 	return true, float64(p.end.Sub(p.start)) / float64(24*time.Hour)
 }
+
+type leadAndCycleTimeState struct {
+	CyclePeriods map[string]period
+	LeadPeriods  map[string]period
+	Segments     map[string]string
+	IssueTypes   map[string]string
+	LastEvent    time.Time
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *LeadAndCycleTime) MarshalState() ([]byte, error) {
+	return json.Marshal(leadAndCycleTimeState{g.cyclePeriods, g.leadPeriods, g.segments, g.issueTypes, g.lastEvent})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *LeadAndCycleTime) UnmarshalState(data []byte) error {
+	var state leadAndCycleTimeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.cyclePeriods, g.leadPeriods = state.CyclePeriods, state.LeadPeriods
+	g.segments, g.issueTypes, g.lastEvent = state.Segments, state.IssueTypes, state.LastEvent
+	return nil
+}
+
+type periodJSON struct {
+	StartSet, EndSet bool
+	Start, End       time.Time
+}
+
+// MarshalJSON implements `json.Marshaler`.
+func (p period) MarshalJSON() ([]byte, error) {
+	return json.Marshal(periodJSON{p.startSet, p.endSet, p.start, p.end})
+}
+
+// UnmarshalJSON implements `json.Unmarshaler`.
+func (p *period) UnmarshalJSON(data []byte) error {
+	var v periodJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.startSet, p.endSet, p.start, p.end = v.StartSet, v.EndSet, v.Start, v.End
+	return nil
+}
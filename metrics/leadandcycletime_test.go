@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestLeadAndCycleTime(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "resolved issue",
+			events: []store.Event{
+				statusChange(at(0, 0), "A", "product", "tribe_a", "backlog", "wip"),
+				statusChange(at(2, 0), "A", "product", "tribe_a", "wip", "done"),
+				statusChange(at(3, 12), "A", "product", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-06T00 cycle_time p/tribe_a/product 2 A",
+				"06-07T12 lead_time p/tribe_a/product 3.5 A",
+			},
+		},
+		{
+			name: "reopened issue",
+			events: []store.Event{
+				statusChange(at(0, 0), "B", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(2, 0), "B", "bug", "tribe_a", "done", "wip"),
+				statusChange(at(4, 0), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(5, 0), "B", "bug", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-08T00 cycle_time p/tribe_a/bug 4 B",
+				"06-09T00 lead_time p/tribe_a/bug 5 B",
+			},
+		},
+		{
+			name: "redundant status transition",
+			events: []store.Event{
+				statusChange(at(0, 0), "C", "ops", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "C", "ops", "tribe_a", "backlog", "wip"),
+				statusChange(at(2, 0), "C", "ops", "tribe_a", "wip", "done"),
+			},
+			expected: []string{
+				"06-06T00 cycle_time p/tribe_a/ops 2 C",
+			},
+		},
+		{
+			name: "unfinished and directly resolved issues",
+			events: []store.Event{
+				statusChange(at(0, 0), "D", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "E", "bug", "tribe_a", "backlog", "resolved"),
+			},
+			expected: []string{
+				"06-05T00 lead_time p/tribe_a/bug 0 E",
+			},
+		},
+		{
+			name: "issue created event sent after the creation",
+			events: []store.Event{
+				createdAt(issueCreated(at(2, 0), "G", "bug", "tribe_a", "backlog"), at(0, 0)),
+				statusChange(at(2, 0), "G", "bug", "tribe_a", "backlog", "resolved"),
+			},
+			expected: []string{
+				"06-06T00 lead_time p/tribe_a/bug 2 G",
+			},
+		},
+		{
+			name: "issue moved to another segment",
+			events: []store.Event{
+				statusChange(at(0, 0), "F", "bug", "tribe_a", "backlog", "wip"),
+				statusChange(at(1, 0), "F", "bug", "tribe_b", "wip", "done"),
+			},
+			expected: []string{
+				"06-05T00 cycle_time p/tribe_b/bug 1 F",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics := generate(t, NewLeadAndCycleTime(), tc.events)
+			assertMetrics(t, withNames(metrics, "lead_time", "cycle_time"), tc.expected)
+		})
+	}
+}
+
+func TestLeadAndCycleTimePercentiles(t *testing.T) {
+	events := []store.Event{
+		statusChange(at(0, 0), "A", "product", "tribe_a", "backlog", "wip"),
+		statusChange(at(0, 0), "B", "bug", "tribe_a", "backlog", "wip"),
+		statusChange(at(1, 0), "C", "product", "tribe_a", "backlog", "wip"),
+		statusChange(at(1, 0), "A", "product", "tribe_a", "wip", "done"),
+		statusChange(at(2, 0), "B", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(5, 0), "C", "product", "tribe_a", "wip", "done"),
+		statusChange(at(6, 0), "D", "product", "tribe_a", "backlog", "wip"),
+	}
+	metrics := generate(t, NewLeadAndCycleTime(), events)
+
+	assertMetrics(t, withNames(metrics, "cycle_time/p50_30d", "cycle_time/p95_30d"), []string{
+		"06-05T00 cycle_time/p50_30d p/tribe_a 1",
+		"06-05T00 cycle_time/p50_30d p/tribe_a/product 1",
+		"06-05T00 cycle_time/p95_30d p/tribe_a 1",
+		"06-05T00 cycle_time/p95_30d p/tribe_a/product 1",
+		"06-06T00 cycle_time/p50_30d p/tribe_a 1",
+		"06-06T00 cycle_time/p50_30d p/tribe_a/bug 2",
+		"06-06T00 cycle_time/p50_30d p/tribe_a/product 1",
+		"06-06T00 cycle_time/p95_30d p/tribe_a 2",
+		"06-06T00 cycle_time/p95_30d p/tribe_a/bug 2",
+		"06-06T00 cycle_time/p95_30d p/tribe_a/product 1",
+		"06-07T00 cycle_time/p50_30d p/tribe_a 1",
+		"06-07T00 cycle_time/p50_30d p/tribe_a/bug 2",
+		"06-07T00 cycle_time/p50_30d p/tribe_a/product 1",
+		"06-07T00 cycle_time/p95_30d p/tribe_a 2",
+		"06-07T00 cycle_time/p95_30d p/tribe_a/bug 2",
+		"06-07T00 cycle_time/p95_30d p/tribe_a/product 1",
+		"06-08T00 cycle_time/p50_30d p/tribe_a 1",
+		"06-08T00 cycle_time/p50_30d p/tribe_a/bug 2",
+		"06-08T00 cycle_time/p50_30d p/tribe_a/product 1",
+		"06-08T00 cycle_time/p95_30d p/tribe_a 2",
+		"06-08T00 cycle_time/p95_30d p/tribe_a/bug 2",
+		"06-08T00 cycle_time/p95_30d p/tribe_a/product 1",
+		"06-09T00 cycle_time/p50_30d p/tribe_a 2",
+		"06-09T00 cycle_time/p50_30d p/tribe_a/bug 2",
+		"06-09T00 cycle_time/p50_30d p/tribe_a/product 1",
+		"06-09T00 cycle_time/p95_30d p/tribe_a 4",
+		"06-09T00 cycle_time/p95_30d p/tribe_a/bug 2",
+		"06-09T00 cycle_time/p95_30d p/tribe_a/product 4",
+	})
+}
+
+func TestLeadAndCycleTimePercentilesIncremental(t *testing.T) {
+	g := NewLeadAndCycleTime()
+	metrics := generate(t, g, []store.Event{
+		statusChange(at(0, 0), "A", "product", "tribe_a", "backlog", "wip"),
+		statusChange(at(1, 0), "A", "product", "tribe_a", "wip", "done"),
+		statusChange(at(1, 12), "B", "product", "tribe_a", "backlog", "wip"),
+	})
+	// The last event's day did not end
+	assertMetrics(t, withNames(metrics, "cycle_time/p50_30d"), []string{})
+
+	metrics = generate(t, g, []store.Event{
+		statusChange(at(1, 18), "B", "product", "tribe_a", "wip", "done"),
+		statusChange(at(2, 0), "C", "product", "tribe_a", "backlog", "wip"),
+	})
+	// Pushed once it ended, with B's cycle time
+	assertMetrics(t, withNames(metrics, "cycle_time/p50_30d"), []string{
+		"06-05T00 cycle_time/p50_30d p/tribe_a 0.25",
+		"06-05T00 cycle_time/p50_30d p/tribe_a/product 0.25",
+	})
+}
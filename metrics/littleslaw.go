@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// LittlesLaw implements `Generator` for the _Little's Law_ metrics.
+type LittlesLaw struct {
+	clock   dayClock
+	issues  map[string]cycleIssue  // issue key -> issue
+	today   map[string]littleDay   // segment -> current day
+	history map[string][]littleDay // segment -> last days, up to the longest rolling window
+}
+
+// littleDay is the flow of a segment during a day.
+type littleDay struct {
+	WIP       int     // number of WIP issues at the end of the day
+	Done      int     // number of issues done during the day
+	CycleTime float64 // sum of the cycle times of the issues done during the day
+}
+
+// NewLittlesLaw returns a `LittlesLaw` struct initialized with
+// internal data.
+func NewLittlesLaw() *LittlesLaw {
+	return &LittlesLaw{
+		issues:  make(map[string]cycleIssue),
+		today:   make(map[string]littleDay),
+		history: make(map[string][]littleDay),
+	}
+}
+
+// Generate generates metrics checking the flow follows Little's Law:
+// average cycle time = average WIP / average throughput.
+//
+// Generated metrics, every day, per segment, for the last 30, 60 and
+// 90 days (or since the segment's first event if more recent):
+//   - Average WIP at the end of the day --> name=littles_law/wip_<window>d
+//   - Average number of issues done per day
+//     --> name=littles_law/throughput_<window>d
+//   - Average cycle time of the issues done
+//     --> name=littles_law/observed_cycle_time_<window>d (not pushed
+//     if no issue was done)
+//   - Cycle time predicted by Little's Law
+//     --> name=littles_law/predicted_cycle_time_<window>d (not pushed
+//     if no issue was done)
+//
+// A gap between the predicted and observed cycle times denotes an
+// unstable flow (e.g. WIP growing) or data-quality issues (e.g.
+// issues never leaving WIP).
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *LittlesLaw) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged && evt.Kind != store.IssueCreated {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		issue := g.issues[evt.IssueKey]
+		issue.Status, issue.Segment = evt.ValueTo, evt.Segment
+		if evt.ValueTo == "wip" && issue.CycleStart.IsZero() {
+			issue.CycleStart = evt.Time
+		}
+		g.issues[evt.IssueKey] = issue
+
+		day := g.today[evt.Segment]
+		if evt.Kind == store.StatusChanged && evt.ValueTo == "done" && !issue.CycleStart.IsZero() {
+			day.Done++
+			day.CycleTime += float64(evt.Time.Sub(issue.CycleStart)) / float64(oneDay)
+		}
+		g.today[evt.Segment] = day
+	}
+
+	log.Printf("[metrics/littles_law] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushMetricsForDay adds the day `d` which just ended to the history
+// and pushes the metrics for the windows ending with it. Returns the
+// number of metrics pushed.
+func (g *LittlesLaw) pushMetricsForDay(d time.Time, w store.MetricWriter) (int, error) {
+	for _, issue := range g.issues {
+		if issue.Status == "wip" {
+			day := g.today[issue.Segment]
+			day.WIP++
+			g.today[issue.Segment] = day
+		}
+	}
+	maxWindow := 0
+	for _, window := range rollingWindows {
+		if window > maxWindow {
+			maxWindow = window
+		}
+	}
+	for segment := range g.history {
+		if _, ok := g.today[segment]; !ok {
+			g.today[segment] = littleDay{}
+		}
+	}
+	for segment, day := range g.today {
+		history := append(g.history[segment], day)
+		if len(history) > maxWindow {
+			history = history[len(history)-maxWindow:]
+		}
+		g.history[segment] = history
+	}
+	g.today = make(map[string]littleDay)
+
+	countMetrics := 0
+	write := func(name, segment string, window int, value float64) error {
+		err := w.WriteMetric(store.Metric{
+			Time:    d,
+			Name:    fmt.Sprintf("littles_law/%s_%dd", name, window),
+			Segment: segment,
+			Value:   value,
+		})
+		if err == nil {
+			countMetrics++
+		}
+		return err
+	}
+
+	for segment, history := range g.history {
+		for _, window := range rollingWindows {
+			days := history
+			if len(days) > window {
+				days = days[len(days)-window:]
+			}
+			var wip, done int
+			var cycleTime float64
+			for _, day := range days {
+				wip, done, cycleTime = wip+day.WIP, done+day.Done, cycleTime+day.CycleTime
+			}
+			avgWIP := float64(wip) / float64(len(days))
+			throughput := float64(done) / float64(len(days))
+
+			if err := write("wip", segment, window, avgWIP); err != nil {
+				return countMetrics, err
+			}
+			if err := write("throughput", segment, window, throughput); err != nil {
+				return countMetrics, err
+			}
+			if done == 0 {
+				continue
+			}
+			if err := write("observed_cycle_time", segment, window, cycleTime/float64(done)); err != nil {
+				return countMetrics, err
+			}
+			if err := write("predicted_cycle_time", segment, window, avgWIP/throughput); err != nil {
+				return countMetrics, err
+			}
+		}
+	}
+	return countMetrics, nil
+}
+
+type littlesLawState struct {
+	Clock   dayClock
+	Issues  map[string]cycleIssue
+	Today   map[string]littleDay
+	History map[string][]littleDay
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *LittlesLaw) MarshalState() ([]byte, error) {
+	return json.Marshal(littlesLawState{g.clock, g.issues, g.today, g.history})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *LittlesLaw) UnmarshalState(data []byte) error {
+	var state littlesLawState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.issues, g.today, g.history = state.Clock, state.Issues, state.Today, state.History
+	return nil
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestLittlesLaw(t *testing.T) {
+	events := []store.Event{
+		statusChange(at(0, 0), "A", "bug", "tribe_a", "backlog", "wip"),
+		statusChange(at(0, 0), "B", "bug", "tribe_a", "backlog", "wip"),
+		statusChange(at(1, 0), "A", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(2, 0), "B", "bug", "tribe_a", "wip", "done"),
+		statusChange(at(3, 0), "C", "bug", "tribe_a", "backlog", "wip"),
+	}
+	metrics := withNames(generate(t, NewLittlesLaw(), events),
+		"littles_law/wip_30d",
+		"littles_law/throughput_30d",
+		"littles_law/observed_cycle_time_30d",
+		"littles_law/predicted_cycle_time_30d",
+	)
+	assertMetrics(t, metrics, []string{
+		"06-04T00 littles_law/wip_30d p/tribe_a 2",
+		"06-04T00 littles_law/throughput_30d p/tribe_a 0",
+		"06-05T00 littles_law/wip_30d p/tribe_a 1.5",
+		"06-05T00 littles_law/throughput_30d p/tribe_a 0.5",
+		"06-05T00 littles_law/observed_cycle_time_30d p/tribe_a 1",
+		"06-05T00 littles_law/predicted_cycle_time_30d p/tribe_a 3",
+		"06-06T00 littles_law/wip_30d p/tribe_a 1",
+		"06-06T00 littles_law/throughput_30d p/tribe_a 0.6666666666666666",
+		"06-06T00 littles_law/observed_cycle_time_30d p/tribe_a 1.5",
+		"06-06T00 littles_law/predicted_cycle_time_30d p/tribe_a 1.5",
+	})
+}
@@ -9,6 +9,16 @@ type Generator interface {
 
 	// Generate generates the metrics from the events received
 	// through the `events` chan and write them using
-	// `PGStore.WriteMetric(..)`
-	Generate(events chan store.Event, segmentPrefix string, s *store.PGStore)
+	// `MetricWriter.WriteMetric(..)`. Returns the first error met,
+	// without reading the remaining events.
+	Generate(events chan store.Event, w store.MetricWriter) error
+
+	// MarshalState returns the generator's internal state, so it
+	// can be saved in a checkpoint.
+	MarshalState() ([]byte, error)
+
+	// UnmarshalState restores the generator's internal state from
+	// a checkpoint. Following calls to `Generate` resume from this
+	// state and only generate new metrics.
+	UnmarshalState(data []byte) error
 }
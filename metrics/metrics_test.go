@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// t0 is the reference time of test events.
+var t0 = time.Date(2018, 6, 4, 0, 0, 0, 0, time.UTC)
+
+// at returns the time `days` days and `hours` hours after `t0`.
+func at(days, hours int) time.Time {
+	return t0.Add(time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour)
+}
+
+// statusChange returns a `status_changed` event for an issue of
+// the project `p` created at `t0`.
+func statusChange(t time.Time, issueKey, issueType, segment, from, to string) store.Event {
+	return store.Event{
+		Time:           t,
+		Kind:           store.StatusChanged,
+		IssueKey:       issueKey,
+		IssueType:      issueType,
+		Segment:        fmt.Sprintf("p/%s", segment),
+		ValueFrom:      from,
+		ValueTo:        to,
+		IssueCreatedAt: t0,
+	}
+}
+
+// issueCreated returns an `issue_created` event for an issue of the
+// project `p` created at `t` in the `status` group.
+func issueCreated(t time.Time, issueKey, issueType, segment, status string) store.Event {
+	evt := createdAt(statusChange(t, issueKey, issueType, segment, "", status), t)
+	evt.Kind = store.IssueCreated
+	return evt
+}
+
+// issueTypeChange returns an `issue_type_changed` event for an issue
+// of the project `p` created at `t0`.
+func issueTypeChange(t time.Time, issueKey, segment, from, to string) store.Event {
+	evt := statusChange(t, issueKey, to, segment, from, to)
+	evt.Kind = store.IssueTypeChanged
+	return evt
+}
+
+// segmentChange returns a `segment_changed` event for an issue of the
+// project `p` created at `t0`.
+func segmentChange(t time.Time, issueKey, issueType, from, to string) store.Event {
+	evt := statusChange(t, issueKey, issueType, to, "p/"+from, "p/"+to)
+	evt.Kind = store.SegmentChanged
+	return evt
+}
+
+// assigneeChange returns an `assignee_changed` event for an issue
+// of the project `p` created at `t0`.
+func assigneeChange(t time.Time, issueKey, issueType, segment, from, to string) store.Event {
+	evt := statusChange(t, issueKey, issueType, segment, from, to)
+	evt.Kind = store.AssigneeChanged
+	return evt
+}
+
+// withStatuses returns the status change event with the Jira statuses
+// `from` and `to`. It is made a `status_changed_in_group` event if the
+// status group does not change.
+func withStatuses(evt store.Event, from, to string) store.Event {
+	evt.StatusFrom, evt.StatusTo = from, to
+	if evt.ValueFrom == evt.ValueTo {
+		evt.Kind = store.StatusChangedInGroup
+	}
+	return evt
+}
+
+// waiting returns the status change event with the new status being
+// a waiting status.
+func waiting(evt store.Event) store.Event {
+	evt.Waiting = true
+	return evt
+}
+
+// createdAt returns the event with `IssueCreatedAt` set to `t`.
+func createdAt(evt store.Event, t time.Time) store.Event {
+	evt.IssueCreatedAt = t
+	return evt
+}
+
+// generate runs the generator `g` on `events` using a `MemStore`
+// and returns the written metrics.
+func generate(t *testing.T, g Generator, events []store.Event) []store.Metric {
+	s := store.NewMemStore(events)
+	eventsChan := make(chan store.Event, 0)
+	go s.StreamEvents(context.Background(), time.Time{}, eventsChan)
+	if err := g.Generate(eventsChan, s); err != nil {
+		t.Fatal(err)
+	}
+	return s.Metrics()
+}
+
+// withNames returns the `metrics` named after one of the `names`.
+func withNames(metrics []store.Metric, names ...string) []store.Metric {
+	filtered := make([]store.Metric, 0)
+	for _, m := range metrics {
+		for _, name := range names {
+			if m.Name == name {
+				filtered = append(filtered, m)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// assertMetrics checks the `metrics` match the `expected` ones, in
+// any order. Expected metrics are formatted as
+// "<time> <name> <segment> <value> [<comment>]", the time being
+// formatted as "01-02T15".
+func assertMetrics(t *testing.T, metrics []store.Metric, expected []string) {
+	actual := make([]string, len(metrics))
+	for i, m := range metrics {
+		actual[i] = formatMetric(m)
+	}
+	sort.Strings(actual)
+	expected = append([]string{}, expected...)
+	sort.Strings(expected)
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected metrics\nexpected:\n  %s\nactual:\n  %s",
+			strings.Join(expected, "\n  "),
+			strings.Join(actual, "\n  "),
+		)
+	}
+}
+
+// formatMetric formats the metric as expected by `assertMetrics`.
+func formatMetric(m store.Metric) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s %g %s",
+		m.Time.Format("01-02T15"), m.Name, m.Segment, m.Value, m.Comment))
+}
+
+func TestCheckpoint(t *testing.T) {
+	events := []store.Event{
+		createdAt(withStatuses(statusChange(at(0, 9), "A", "product", "tribe_a", "wip", "backlog"), "in_review", "open"), at(-3, 0)),
+		withStatuses(statusChange(at(0, 10), "B", "bug", "tribe_a", "backlog", "wip"), "open", "in_development"),
+		withStatuses(statusChange(at(1, 10), "B", "bug", "tribe_a", "wip", "done"), "in_development", "ready"),
+		withStatuses(statusChange(at(2, 10), "A", "product", "tribe_a", "backlog", "wip"), "open", "in_development"),
+		withStatuses(statusChange(at(3, 10), "B", "bug", "tribe_a", "done", "resolved"), "ready", "closed"),
+		withStatuses(statusChange(at(4, 10), "A", "product", "tribe_a", "wip", "done"), "in_development", "ready"),
+		issueCreated(at(4, 12), "C", "bug", "tribe_a", "backlog"),
+		// Created before the checkpoint but sent with its first event
+		createdAt(issueCreated(at(4, 13), "D", "bug", "tribe_a", "backlog"), at(1, 0)),
+		withStatuses(statusChange(at(4, 13), "D", "bug", "tribe_a", "backlog", "wip"), "open", "in_development"),
+		withStatuses(statusChange(at(4, 14), "D", "bug", "tribe_a", "wip", "resolved"), "in_development", "closed"),
+	}
+	splits := map[string]int{
+		"later day": 3, // resuming on a later day than the checkpoint
+		"same day":  6, // resuming on the checkpoint's day
+	}
+
+	generators := map[string]func() Generator{
+		"aging_wip":           func() Generator { return NewAgingWIP() },
+		"contributors":        func() Generator { return NewContributors() },
+		"counters":            func() Generator { return NewCounters() },
+		"flow_efficiency":     func() Generator { return NewFlowEfficiency() },
+		"issues_age":          func() Generator { return newIssuesAge() },
+		"lead_and_cycle_time": func() Generator { return NewLeadAndCycleTime() },
+		"littles_law":         func() Generator { return NewLittlesLaw() },
+		"rework":              func() Generator { return NewRework() },
+		"throughput":          func() Generator { return NewThroughput() },
+		"time_in_status":      func() Generator { return NewTimeInStatus() },
+	}
+	for name, newGenerator := range generators {
+		for splitName, split := range splits {
+			newGenerator, split := newGenerator, split
+			t.Run(name+"/"+splitName, func(t *testing.T) {
+				expected := make([]string, 0)
+				for _, m := range generate(t, newGenerator(), events) {
+					expected = append(expected, formatMetric(m))
+				}
+
+				g := newGenerator()
+				metrics := generate(t, g, events[:split])
+				state, err := g.MarshalState()
+				if err != nil {
+					t.Fatal(err)
+				}
+				g = newGenerator()
+				if err := g.UnmarshalState(state); err != nil {
+					t.Fatal(err)
+				}
+				metrics = append(metrics, generate(t, g, events[split:])...)
+
+				assertMetrics(t, metrics, expected)
+			})
+		}
+	}
+}
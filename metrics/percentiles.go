@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Percentiles and windows (in days) of the rolling percentiles
+// metrics.
+var (
+	rollingPercentiles = []int{50, 85, 95}
+	rollingWindows     = []int{30, 60, 90}
+)
+
+// dataPoint is a value (e.g. the cycle time of an issue) at a
+// given time (e.g. the time the issue was done).
+type dataPoint struct {
+	time  time.Time
+	value float64
+}
+
+// percentile returns the `p`th percentile of the `sorted` values,
+// using the nearest-rank method. `sorted` must not be empty.
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// pushRollingPercentiles pushes, for each day from `from` to `to`
+// (included) and each segment, the `rollingPercentiles` of the values
+// of the data points in each of the `rollingWindows` ending with the
+// day.
+//
+// Metrics are named `<name>/p<percentile>_<window>d` (e.g.
+// `cycle_time/p85_30d`). No metric is pushed for a window without any
+// data point.
+//
+// Returns the number of metrics pushed.
+func pushRollingPercentiles(w store.MetricWriter, name string, points map[string][]dataPoint, from, to time.Time) (int, error) {
+	countMetrics := 0
+	for _, segmentPoints := range points {
+		sort.Slice(segmentPoints, func(i, j int) bool {
+			return segmentPoints[i].time.Before(segmentPoints[j].time)
+		})
+	}
+
+	for d := from; !d.After(to); d = d.Add(oneDay) {
+		windowEnd := d.Add(oneDay)
+		for segment, segmentPoints := range points {
+			// Index of the first point after the window's end
+			last := sort.Search(len(segmentPoints), func(i int) bool {
+				return !segmentPoints[i].time.Before(windowEnd)
+			})
+
+			for _, window := range rollingWindows {
+				windowStart := windowEnd.Add(-time.Duration(window) * oneDay)
+				first := sort.Search(last, func(i int) bool {
+					return !segmentPoints[i].time.Before(windowStart)
+				})
+				if first == last {
+					continue
+				}
+
+				values := make([]float64, 0, last-first)
+				for _, p := range segmentPoints[first:last] {
+					values = append(values, p.value)
+				}
+				sort.Float64s(values)
+
+				for _, p := range rollingPercentiles {
+					err := w.WriteMetric(store.Metric{
+						Time:    d,
+						Name:    fmt.Sprintf("%s/p%d_%dd", name, p, window),
+						Segment: segment,
+						Value:   percentile(values, p),
+					})
+					if err != nil {
+						return countMetrics, err
+					}
+					countMetrics++
+				}
+			}
+		}
+	}
+	return countMetrics, nil
+}
+
+type dataPointJSON struct {
+	Time  time.Time
+	Value float64
+}
+
+// MarshalJSON implements `json.Marshaler`.
+func (p dataPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dataPointJSON{p.time, p.value})
+}
+
+// UnmarshalJSON implements `json.Unmarshaler`.
+func (p *dataPoint) UnmarshalJSON(data []byte) error {
+	var v dataPointJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.time, p.value = v.Time, v.Value
+	return nil
+}
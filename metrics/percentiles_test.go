@@ -0,0 +1,24 @@
+package metrics
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	testCases := []struct {
+		values   []float64
+		p        int
+		expected float64
+	}{
+		{[]float64{3}, 50, 3},
+		{[]float64{3}, 95, 3},
+		{[]float64{1, 2, 3, 4}, 50, 2},
+		{[]float64{1, 2, 3, 4}, 85, 4},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 50, 5},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 85, 9},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 10},
+	}
+	for _, tc := range testCases {
+		if actual := percentile(tc.values, tc.p); actual != tc.expected {
+			t.Errorf("percentile(%v, %d): expected %g, got %g", tc.values, tc.p, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// backwardTransitions are the status changes considered as reopening
+// an issue (status group from -> status groups to).
+var backwardTransitions = map[string][]string{
+	"done":     {"wip"},
+	"resolved": {"backlog", "wip"},
+}
+
+// Rework implements `Generator` for the _Rework_ metrics.
+type Rework struct {
+	clock    dayClock
+	reopened map[string][]string // segment -> keys of the issues reopened during the current week
+	done     map[string]int      // segment -> number of issues done during the current week
+	segments map[string]bool     // segments seen so far
+}
+
+// NewRework returns a `Rework` struct initialized with internal data.
+func NewRework() *Rework {
+	return &Rework{
+		reopened: make(map[string][]string),
+		done:     make(map[string]int),
+		segments: make(map[string]bool),
+	}
+}
+
+// Generate generates metrics on the issues reopened, i.e. moving
+// backward from `done` to `wip`, or from `resolved` to `backlog` or
+// `wip`.
+//
+// Generated metrics, every week, per segment (time=monday of the week):
+//   - Number of reopens --> name=rework/weekly_reopened,
+//     comment=<keys of the reopened issues>
+//   - Number of reopens divided by the number of issues done during the
+//     week --> name=rework/weekly_ratio (not pushed if no issue was done)
+//
+// Metrics for a week are pushed once an event from a later week has
+// been received.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *Rework) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			if next := d.Add(oneDay); !next.Equal(weekOf(next)) {
+				return nil
+			}
+			n, err := g.pushMetricsForWeek(weekOf(d), w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		g.segments[evt.Segment] = true
+		for _, to := range backwardTransitions[evt.ValueFrom] {
+			if evt.ValueTo == to {
+				g.reopened[evt.Segment] = append(g.reopened[evt.Segment], evt.IssueKey)
+			}
+		}
+		if evt.ValueTo == "done" {
+			g.done[evt.Segment]++
+		}
+	}
+
+	log.Printf("[metrics/rework] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushMetricsForWeek pushes the metrics for the week starting on
+// `monday` which just ended. Returns the number of metrics pushed.
+func (g *Rework) pushMetricsForWeek(monday time.Time, w store.MetricWriter) (int, error) {
+	countMetrics := 0
+	for segment := range g.segments {
+		keys := g.reopened[segment]
+		sort.Strings(keys)
+		err := w.WriteMetric(store.Metric{
+			Time:    monday,
+			Name:    "rework/weekly_reopened",
+			Segment: segment,
+			Value:   float64(len(keys)),
+			Comment: strings.Join(keys, ","),
+		})
+		if err != nil {
+			return countMetrics, err
+		}
+		countMetrics++
+
+		if g.done[segment] == 0 {
+			continue
+		}
+		err = w.WriteMetric(store.Metric{
+			Time:    monday,
+			Name:    "rework/weekly_ratio",
+			Segment: segment,
+			Value:   float64(len(keys)) / float64(g.done[segment]),
+		})
+		if err != nil {
+			return countMetrics, err
+		}
+		countMetrics++
+	}
+	g.reopened = make(map[string][]string)
+	g.done = make(map[string]int)
+	return countMetrics, nil
+}
+
+type reworkState struct {
+	Clock    dayClock
+	Reopened map[string][]string
+	Done     map[string]int
+	Segments map[string]bool
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *Rework) MarshalState() ([]byte, error) {
+	return json.Marshal(reworkState{g.clock, g.reopened, g.done, g.segments})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *Rework) UnmarshalState(data []byte) error {
+	var state reworkState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.reopened, g.done, g.segments = state.Clock, state.Reopened, state.Done, state.Segments
+	return nil
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestRework(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "reopened issues",
+			events: []store.Event{
+				statusChange(at(0, 9), "A", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(0, 10), "B", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(1, 9), "A", "bug", "tribe_a", "done", "wip"),
+				statusChange(at(2, 9), "C", "bug", "tribe_a", "resolved", "backlog"),
+				statusChange(at(3, 9), "B", "bug", "tribe_a", "done", "resolved"),
+				statusChange(at(4, 9), "D", "bug", "tribe_b", "backlog", "wip"),
+				statusChange(at(5, 9), "D", "bug", "tribe_b", "wip", "backlog"),
+				statusChange(at(7, 9), "D", "bug", "tribe_b", "backlog", "resolved"),
+				statusChange(at(8, 9), "D", "bug", "tribe_b", "resolved", "wip"),
+				statusChange(at(14, 9), "D", "bug", "tribe_b", "wip", "done"),
+			},
+			expected: []string{
+				"06-04T00 rework/weekly_reopened p/tribe_a 2 A,C",
+				"06-04T00 rework/weekly_ratio p/tribe_a 1",
+				"06-04T00 rework/weekly_reopened p/tribe_b 0",
+				"06-11T00 rework/weekly_reopened p/tribe_a 0",
+				"06-11T00 rework/weekly_reopened p/tribe_b 1 D",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewRework(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// Throughput implements `Generator` for the _Throughput_ metric.
+type Throughput struct {
+	clock    dayClock
+	daily    map[string]map[string]int // status[/issue type] -> segment -> count for the current day
+	weekly   map[string]map[string]int // status[/issue type] -> segment -> count for the current week
+	segments map[string]bool           // segments seen so far
+}
+
+// NewThroughput returns a `Throughput` struct initialized with
+// internal data.
+func NewThroughput() *Throughput {
+	return &Throughput{
+		daily:    make(map[string]map[string]int),
+		weekly:   make(map[string]map[string]int),
+		segments: make(map[string]bool),
+	}
+}
+
+// Generate generates metrics counting the issues reaching the `done`
+// and `resolved` statuses.
+//
+// Generated metrics, per segment:
+//   - Daily throughput --> name=throughput/daily_(done|resolved), time=day
+//   - Weekly throughput --> name=throughput/weekly_(done|resolved), time=monday of the week
+//   - Same metrics per issue type --> name=throughput/(daily|weekly)_(done|resolved)/<issue type>
+//
+// Metrics per segment are pushed for every day and week, including those
+// without any issue done or resolved. Metrics per issue type are only
+// pushed when not 0.
+//
+// Metrics for a day (resp. week) are pushed once an event from a later
+// day (resp. week) has been received, so the current day and week are
+// not reported until they are complete.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *Throughput) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushMetricsForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		g.segments[evt.Segment] = true
+		switch evt.ValueTo {
+		case "done", "resolved":
+			for _, key := range []string{evt.ValueTo, fmt.Sprintf("%s/%s", evt.ValueTo, evt.IssueType)} {
+				increment(g.daily, key, evt.Segment)
+				increment(g.weekly, key, evt.Segment)
+			}
+		}
+	}
+
+	log.Printf("[metrics/throughput] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushMetricsForDay pushes the metrics for the day `d` which just
+// ended, and for its week if it's the last day of the week. Returns
+// the number of metrics pushed.
+func (g *Throughput) pushMetricsForDay(d time.Time, w store.MetricWriter) (int, error) {
+	countMetrics, err := g.pushCounts(w, d, "daily", g.daily)
+	g.daily = make(map[string]map[string]int)
+	if err != nil {
+		return countMetrics, err
+	}
+
+	if next := d.Add(oneDay); next.Equal(weekOf(next)) {
+		n, err := g.pushCounts(w, weekOf(d), "weekly", g.weekly)
+		countMetrics += n
+		g.weekly = make(map[string]map[string]int)
+		if err != nil {
+			return countMetrics, err
+		}
+	}
+	return countMetrics, nil
+}
+
+func (g *Throughput) pushCounts(w store.MetricWriter, t time.Time, period string, counts map[string]map[string]int) (int, error) {
+	countMetrics := 0
+	write := func(key, segment string, value int) error {
+		err := w.WriteMetric(store.Metric{
+			Time:    t,
+			Name:    fmt.Sprintf("throughput/%s_%s", period, key),
+			Segment: segment,
+			Value:   float64(value),
+		})
+		if err == nil {
+			countMetrics++
+		}
+		return err
+	}
+
+	for segment := range g.segments {
+		for _, status := range []string{"done", "resolved"} {
+			if err := write(status, segment, counts[status][segment]); err != nil {
+				return countMetrics, err
+			}
+		}
+	}
+	for key, segments := range counts {
+		if key == "done" || key == "resolved" {
+			continue
+		}
+		for segment, value := range segments {
+			if err := write(key, segment, value); err != nil {
+				return countMetrics, err
+			}
+		}
+	}
+	return countMetrics, nil
+}
+
+// increment increments the count for `key` and `segment` in `counts`.
+func increment(counts map[string]map[string]int, key, segment string) {
+	if _, ok := counts[key]; !ok {
+		counts[key] = make(map[string]int)
+	}
+	counts[key][segment]++
+}
+
+type throughputState struct {
+	Clock    dayClock
+	Daily    map[string]map[string]int
+	Weekly   map[string]map[string]int
+	Segments map[string]bool
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *Throughput) MarshalState() ([]byte, error) {
+	return json.Marshal(throughputState{g.clock, g.daily, g.weekly, g.segments})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *Throughput) UnmarshalState(data []byte) error {
+	var state throughputState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.daily, g.weekly, g.segments = state.Clock, state.Daily, state.Weekly, state.Segments
+	return nil
+}
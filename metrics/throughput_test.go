@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestThroughput(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "daily and weekly throughput",
+			events: []store.Event{
+				statusChange(at(5, 10), "A", "bug", "tribe_a", "wip", "done"),
+				statusChange(at(6, 9), "B", "product", "tribe_a", "done", "resolved"),
+				statusChange(at(7, 10), "C", "product", "tribe_b", "wip", "done"),
+			},
+			expected: []string{
+				"06-09T00 throughput/daily_done p/tribe_a 1",
+				"06-09T00 throughput/daily_done/bug p/tribe_a 1",
+				"06-09T00 throughput/daily_resolved p/tribe_a 0",
+				"06-10T00 throughput/daily_done p/tribe_a 0",
+				"06-10T00 throughput/daily_resolved p/tribe_a 1",
+				"06-10T00 throughput/daily_resolved/product p/tribe_a 1",
+				"06-04T00 throughput/weekly_done p/tribe_a 1",
+				"06-04T00 throughput/weekly_done/bug p/tribe_a 1",
+				"06-04T00 throughput/weekly_resolved p/tribe_a 1",
+				"06-04T00 throughput/weekly_resolved/product p/tribe_a 1",
+			},
+		},
+		{
+			name: "days without events",
+			events: []store.Event{
+				statusChange(at(0, 10), "A", "ops", "tribe_a", "wip", "done"),
+				statusChange(at(2, 10), "A", "ops", "tribe_a", "done", "resolved"),
+			},
+			expected: []string{
+				"06-04T00 throughput/daily_done p/tribe_a 1",
+				"06-04T00 throughput/daily_done/ops p/tribe_a 1",
+				"06-04T00 throughput/daily_resolved p/tribe_a 0",
+				"06-05T00 throughput/daily_done p/tribe_a 0",
+				"06-05T00 throughput/daily_resolved p/tribe_a 0",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewThroughput(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+// TimeInStatus implements `Generator` for the _Time in status_
+// metric.
+type TimeInStatus struct {
+	clock  dayClock
+	issues map[string]statusTimes        // issue key -> time spent in each status
+	sums   map[string]map[string]float64 // status -> segment -> sum of the times of issues resolved during the current day
+	counts map[string]map[string]int     // status -> segment -> number of issues resolved during the current day
+}
+
+// statusTimes tracks the time an issue spent in each (Jira) status.
+type statusTimes struct {
+	Status    string             // current status
+	Since     time.Time          // time the issue entered the current status
+	Durations map[string]float64 // status -> days spent in the status
+}
+
+// NewTimeInStatus returns a `TimeInStatus` struct initialized with
+// internal data.
+func NewTimeInStatus() *TimeInStatus {
+	return &TimeInStatus{
+		issues: make(map[string]statusTimes),
+		sums:   make(map[string]map[string]float64),
+		counts: make(map[string]map[string]int),
+	}
+}
+
+// Generate generates metrics on the time spent by issues in each Jira
+// status (not in each status group, e.g. `in_development` and
+// `in_review` are distinct statuses of the `wip` group).
+//
+// Generated metrics, per segment:
+//   - When an issue is resolved, the days it spent in each status
+//     since its creation --> name=time_in_status/<status>,
+//     comment=<issue key>
+//   - Every day, the average of these times for the issues resolved
+//     during the day --> name=time_in_status_avg/<status>, time=day
+//
+// The issue is considered in the status it left with its first event
+// since its creation.
+//
+// NB: `events` must be sent in *ascending order on time*.
+func (g *TimeInStatus) Generate(events chan store.Event, w store.MetricWriter) error {
+	countMetrics := 0
+
+	for evt := range events {
+		if evt.Kind != store.StatusChanged && evt.Kind != store.StatusChangedInGroup && evt.Kind != store.IssueCreated {
+			continue
+		}
+		err := g.clock.advance(evt.Time, func(d time.Time) error {
+			n, err := g.pushAveragesForDay(d, w)
+			countMetrics += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		issue, ok := g.issues[evt.IssueKey]
+		if !ok {
+			issue = statusTimes{
+				Status:    evt.StatusFrom,
+				Since:     evt.IssueCreatedAt,
+				Durations: make(map[string]float64),
+			}
+			if issue.Since.IsZero() || issue.Since.After(evt.Time) {
+				issue.Since = evt.Time
+			}
+		}
+		if issue.Status != "" {
+			issue.Durations[issue.Status] += float64(evt.Time.Sub(issue.Since)) / float64(oneDay)
+		}
+		issue.Status = evt.StatusTo
+		if evt.Kind != store.IssueCreated {
+			// An issue created before a checkpoint may be sent its
+			// creation event later: keeping the creation time.
+			issue.Since = evt.Time
+		}
+		g.issues[evt.IssueKey] = issue
+
+		if evt.Kind == store.StatusChanged && evt.ValueTo == "resolved" {
+			n, err := g.pushIssueTimes(evt, issue, w)
+			countMetrics += n
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("[metrics/time_in_status] pushed %d metrics\n",
+		countMetrics,
+	)
+	return nil
+}
+
+// pushIssueTimes pushes the time spent in each status by the issue
+// resolved by `evt`, and adds them to the day's averages. Returns the
+// number of metrics pushed.
+func (g *TimeInStatus) pushIssueTimes(evt store.Event, issue statusTimes, w store.MetricWriter) (int, error) {
+	countMetrics := 0
+	for status, days := range issue.Durations {
+		err := w.WriteMetric(store.Metric{
+			Time:    evt.Time,
+			Name:    fmt.Sprintf("time_in_status/%s", status),
+			Segment: evt.Segment,
+			Value:   days,
+			Comment: evt.IssueKey,
+		})
+		if err != nil {
+			return countMetrics, err
+		}
+		countMetrics++
+
+		if _, ok := g.sums[status]; !ok {
+			g.sums[status] = make(map[string]float64)
+		}
+		g.sums[status][evt.Segment] += days
+		increment(g.counts, status, evt.Segment)
+	}
+	return countMetrics, nil
+}
+
+// pushAveragesForDay pushes the average times in status of the issues
+// resolved during the day `d` which just ended. Returns the number of
+// metrics pushed.
+func (g *TimeInStatus) pushAveragesForDay(d time.Time, w store.MetricWriter) (int, error) {
+	countMetrics := 0
+	for status, segments := range g.counts {
+		for segment, count := range segments {
+			err := w.WriteMetric(store.Metric{
+				Time:    d,
+				Name:    fmt.Sprintf("time_in_status_avg/%s", status),
+				Segment: segment,
+				Value:   g.sums[status][segment] / float64(count),
+			})
+			if err != nil {
+				return countMetrics, err
+			}
+			countMetrics++
+		}
+	}
+	g.sums = make(map[string]map[string]float64)
+	g.counts = make(map[string]map[string]int)
+	return countMetrics, nil
+}
+
+type timeInStatusState struct {
+	Clock  dayClock
+	Issues map[string]statusTimes
+	Sums   map[string]map[string]float64
+	Counts map[string]map[string]int
+}
+
+// MarshalState implements `Generator.MarshalState`.
+func (g *TimeInStatus) MarshalState() ([]byte, error) {
+	return json.Marshal(timeInStatusState{g.clock, g.issues, g.sums, g.counts})
+}
+
+// UnmarshalState implements `Generator.UnmarshalState`.
+func (g *TimeInStatus) UnmarshalState(data []byte) error {
+	var state timeInStatusState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	g.clock, g.issues, g.sums, g.counts = state.Clock, state.Issues, state.Sums, state.Counts
+	return nil
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer/store"
+)
+
+func TestTimeInStatus(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []store.Event
+		expected []string
+	}{
+		{
+			name: "resolved issues",
+			events: []store.Event{
+				createdAt(withStatuses(statusChange(at(0, 0), "A", "bug", "tribe_a", "backlog", "wip"), "open", "in_development"), at(-1, 0)),
+				withStatuses(statusChange(at(1, 0), "A", "bug", "tribe_a", "wip", "wip"), "in_development", "in_review"),
+				withStatuses(statusChange(at(1, 12), "A", "bug", "tribe_a", "wip", "wip"), "in_review", "in_development"),
+				withStatuses(statusChange(at(2, 0), "A", "bug", "tribe_a", "wip", "done"), "in_development", "ready"),
+				withStatuses(statusChange(at(3, 0), "A", "bug", "tribe_a", "done", "resolved"), "ready", "closed"),
+				withStatuses(statusChange(at(3, 12), "B", "bug", "tribe_a", "wip", "wip"), "in_development", "in_review"),
+				withStatuses(statusChange(at(3, 18), "B", "bug", "tribe_a", "wip", "resolved"), "in_review", "closed"),
+				withStatuses(statusChange(at(4, 0), "C", "bug", "tribe_a", "backlog", "wip"), "open", "in_development"),
+			},
+			expected: []string{
+				"06-07T00 time_in_status/open p/tribe_a 1 A",
+				"06-07T00 time_in_status/in_development p/tribe_a 1.5 A",
+				"06-07T00 time_in_status/in_review p/tribe_a 0.5 A",
+				"06-07T00 time_in_status/ready p/tribe_a 1 A",
+				"06-07T18 time_in_status/in_development p/tribe_a 3.5 B",
+				"06-07T18 time_in_status/in_review p/tribe_a 0.25 B",
+				"06-07T00 time_in_status_avg/open p/tribe_a 1",
+				"06-07T00 time_in_status_avg/in_development p/tribe_a 2.5",
+				"06-07T00 time_in_status_avg/in_review p/tribe_a 0.375",
+				"06-07T00 time_in_status_avg/ready p/tribe_a 1",
+			},
+		},
+		{
+			name: "issue created event sent after the creation",
+			events: []store.Event{
+				withStatuses(createdAt(issueCreated(at(1, 0), "D", "bug", "tribe_a", "backlog"), at(0, 0)), "", "open"),
+				withStatuses(statusChange(at(1, 0), "D", "bug", "tribe_a", "backlog", "resolved"), "open", "closed"),
+			},
+			expected: []string{
+				"06-05T00 time_in_status/open p/tribe_a 1 D",
+			},
+		},
+		{
+			name: "current day not pushed",
+			events: []store.Event{
+				withStatuses(statusChange(at(0, 0), "A", "ops", "tribe_a", "wip", "resolved"), "in_development", "closed"),
+			},
+			expected: []string{
+				"06-04T00 time_in_status/in_development p/tribe_a 0 A",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertMetrics(t, generate(t, NewTimeInStatus(), tc.events), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemStore implements `EventSource` and `MetricWriter` in memory.
+// It streams a predefined list of events and keeps the written
+// metrics, so generators can be run without a database (e.g. in
+// tests).
+type MemStore struct {
+	sync.Mutex
+	events  []Event
+	metrics []Metric
+}
+
+var _ EventSource = (*MemStore)(nil)
+var _ MetricWriter = (*MemStore)(nil)
+
+// NewMemStore returns a `MemStore` which will stream the
+// specified events. Events should be ordered by time ascending.
+func NewMemStore(events []Event) *MemStore {
+	return &MemStore{events: events}
+}
+
+// StreamEvents sends the store's events which happened after
+// `since` to `events`.
+func (s *MemStore) StreamEvents(ctx context.Context, since time.Time, events chan<- Event) error {
+	defer close(events)
+	for _, evt := range s.events {
+		if !evt.Time.After(since) {
+			continue
+		}
+		select {
+		case events <- evt:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// WriteMetric keeps the metric in memory.
+func (s *MemStore) WriteMetric(metric Metric) error {
+	s.Lock()
+	defer s.Unlock()
+	s.metrics = append(s.metrics, metric)
+	return nil
+}
+
+// Metrics returns the metrics written so far.
+func (s *MemStore) Metrics() []Metric {
+	s.Lock()
+	defer s.Unlock()
+	metrics := make([]Metric, len(s.metrics))
+	copy(metrics, s.metrics)
+	return metrics
+}
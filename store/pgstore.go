@@ -1,9 +1,9 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"github.com/rchampourlier/golib/slices"
 	"log"
 	"regexp"
 	"strings"
@@ -11,73 +11,94 @@ import (
 	"time"
 
 	pq "github.com/lib/pq" // PG engine for database/sql
+
+	"github.com/rchampourlier/kaizenizer/config"
 )
 
+// Unmapped is the group of statuses and issue types which are not
+// mapped in the configuration (unless the store is strict).
+const Unmapped = "unmapped"
+
+// MetricsTable is the table metrics are read from (e.g. by Grafana).
+const MetricsTable = "metrics"
+
+// StagingMetricsTable is the table metrics are written to during a
+// full generation, before replacing `MetricsTable`.
+const StagingMetricsTable = "metrics_new"
+
 // BatchSize is the max size of slices sent to the database
 // through bulk imports.
 const BatchSize = 10000
 
-// PGStore implements the application's `Store` with a
-// Postgres DB backend.
+// PGStore implements the application's `EventSource` and
+// `MetricWriter` with Postgres DB backends: events are read from the
+// source DB, everything else is read from and written to the sink DB.
 type PGStore struct {
-	*sql.DB
+	*sql.DB         // sink
+	source          *sql.DB
 	*sync.WaitGroup // wait for all metrics received to be written
 	metrics         chan Metric
+	metricsTable    string // table metrics are written to
+	config          *config.Config
+	strict          bool
+	unmapped        map[string]map[string]int // kind (status|issue_type) -> unmapped value -> count
+
+	errMutex sync.Mutex
+	err      error // first error met writing metrics
 }
 
-// NewPGStore returns a `PGStore` storing the specified DB.
-// The passed DB should already be open and ready to
-// receive queries.
-func NewPGStore(db *sql.DB) *PGStore {
+var _ EventSource = (*PGStore)(nil)
+var _ MetricWriter = (*PGStore)(nil)
+
+// NewPGStore returns a `PGStore` reading events from the `source`
+// DB and writing to the `sink` DB (which may be the same). The passed
+// DBs should already be open and ready to receive queries. Only
+// `StreamEvents` queries `source`, so it may be a read-only replica.
+// The `cfg` configuration is used to map Jira statuses, issue types
+// and segments.
+func NewPGStore(source, sink *sql.DB, cfg *config.Config) *PGStore {
 	metrics := make(chan Metric, 0)
 	s := PGStore{
-		db,
-		&sync.WaitGroup{},
-		metrics,
+		DB:           sink,
+		source:       source,
+		WaitGroup:    &sync.WaitGroup{},
+		metrics:      metrics,
+		metricsTable: MetricsTable,
+		config:       cfg,
+		unmapped:     make(map[string]map[string]int),
 	}
 	go s.processMetricsFromChan(metrics)
 	return &s
 }
 
-// Metric represents a metric to be stored to the DB.
-type Metric struct {
-	Time    time.Time
-	Name    string
-	Segment string
-	Value   float64
-	Comment string
+// SetStrict sets the store's strict mode. When strict, the first
+// status or issue type not matching the configuration makes
+// `StreamEvents` fail. Otherwise, it is mapped to the `Unmapped` group and
+// reported by `WriteMappingReport`.
+func (s *PGStore) SetStrict(strict bool) {
+	s.strict = strict
 }
 
-// Event represents the Event loaded from the database,
-// generated by [Jira Source]() (from the
-// `jira_issues_events` table).
-type Event struct {
-	Time           time.Time
-	Kind           string
-	IssueKey       string
-	IssueType      string
-	Segment        string
-	ValueFrom      string
-	ValueTo        string
-	IssueCreatedAt time.Time
-}
-
-func (e Event) String() string {
-	return fmt.Sprintf("{EVENT:%s - %s - issue:%s - from:%s - to:%s}", e.Kind, e.Time.Format(time.RFC3339), e.IssueKey, e.ValueFrom, e.ValueTo)
-}
-
-// WriteMetric writes a metric record to the database.
-func (s *PGStore) WriteMetric(metric Metric) {
+// WriteMetric sends a metric record to be written to the database.
+// Metrics are written asynchronously, by batches: the returned error
+// is the one met writing a previous batch, if any.
+func (s *PGStore) WriteMetric(metric Metric) error {
+	if err := s.writeErr(); err != nil {
+		return err
+	}
 	s.Add(1)
 	s.metrics <- metric
+	return nil
 }
 
 // DoneAndWait should be called when all metrics have been sent for writing.
 // It will close the `s.metrics` channel so the last batch can be written.
-// Blocks until the last batch has been written to database.
-func (s *PGStore) DoneAndWait() {
+// Blocks until the last batch has been written to database and returns
+// the first error met writing metrics.
+func (s *PGStore) DoneAndWait() error {
 	close(s.metrics)
 	s.Wait()
+	return s.writeErr()
 }
 
 func (s *PGStore) processMetricsFromChan(metrics chan Metric) {
@@ -97,149 +118,580 @@ func (s *PGStore) processMetricsFromChan(metrics chan Metric) {
 	s.writeMetricsBatch(metricsBatch[:i])
 }
 
+// writeMetricsBatch writes the batch in a transaction. Once an error
+// has been met, following batches are discarded.
 func (s *PGStore) writeMetricsBatch(metricsBatch []Metric) {
-	txn, err := s.Begin()
+	defer s.Add(-len(metricsBatch)) // mark done for all processed metrics
+
+	if s.writeErr() != nil {
+		return
+	}
+
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		stmt, err := txn.Prepare(pq.CopyIn(s.metricsTable, "time", "name", "segment", "value", "comment"))
+		if err != nil {
+			return err
+		}
+		for _, metric := range metricsBatch {
+			_, err = stmt.Exec(metric.Time, metric.Name, metric.Segment, metric.Value, metric.Comment)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		_, err = stmt.Exec()
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+		return stmt.Close()
+	})
 	if err != nil {
-		log.Fatal(err)
+		s.errMutex.Lock()
+		s.err = fmt.Errorf("error writing metrics to `%s`: %s", s.metricsTable, err)
+		s.errMutex.Unlock()
+		return
 	}
 
-	stmt, err := txn.Prepare(pq.CopyIn("metrics", "time", "name", "segment", "value", "comment"))
+	log.Printf("[store] %d metrics written\n", len(metricsBatch))
+}
+
+func (s *PGStore) writeErr() error {
+	s.errMutex.Lock()
+	defer s.errMutex.Unlock()
+	return s.err
+}
+
+// StreamEvents sends the `Event` records from the database's
+// `jira_issues_events` table to `events`, for all projects, for events
+// which happened after `since`. The `events` channel is closed when
+// done.
+//
+// Status changes are sent as `StatusChanged` events if the status
+// group changed, `StatusChangedInGroup` otherwise.
+//
+// An `IssueCreated` event is synthesized for each issue at its
+// creation time (or at its first event, of any kind, if earlier), its
+// initial status being the status its first status change is from.
+// Issues are thus known by generators from their creation, even if they
+// never changed status. It is sent if the issue's first event happened
+// after `since`, at the time of this event if the creation happened
+// before `since` (its `IssueCreatedAt` is kept).
+//
+// When an event shows the issue type group or segment of an issue
+// changed since its previous event, an `IssueTypeChanged` or
+// `SegmentChanged` event is sent before it. When resuming after
+// `since`, the previous event may be one which happened before it.
+//
+// Stops and returns an error if the query fails, if a value does not
+// match the configuration in strict mode or if `ctx` is cancelled.
+func (s *PGStore) StreamEvents(ctx context.Context, since time.Time, events chan<- Event) error {
+	defer close(events)
+
+	query := fmt.Sprintf(`
+		SELECT * FROM (
+			SELECT 
+				event_time,
+				event_kind,
+				issue_key, 
+				issue_project,
+				issue_type,
+				%[1]s AS issue_segment,
+				status_change_from,
+				status_change_to,
+				assignee_change_from,
+				assignee_change_to,
+				issue_created_at
+			FROM jira_issues_events
+			WHERE event_time > $1
+			UNION ALL (
+				SELECT DISTINCT ON (issue_key)
+					CASE WHEN LEAST(issue_created_at, first_event_time) > $1
+						THEN LEAST(issue_created_at, first_event_time)
+						ELSE first_event_time
+					END,
+					'%[2]s',
+					issue_key,
+					issue_project,
+					issue_type,
+					%[1]s,
+					NULL,
+					status_change_from,
+					NULL,
+					NULL,
+					issue_created_at
+				FROM (
+					SELECT *, MIN(event_time) OVER (PARTITION BY issue_key) AS first_event_time
+					FROM jira_issues_events
+				) AS issues_events
+				WHERE first_event_time > $1
+				ORDER BY issue_key, event_kind = '%[3]s' DESC, event_time ASC
+			)
+		) AS events
+		ORDER BY event_time ASC, event_kind = '%[2]s' DESC
+		`, s.config.Segment.Column, IssueCreated, StatusChanged)
+	// Read before querying events, so a single connection is enough
+	issues, err := s.lastIssueEvents(ctx, since) // issue key -> last event
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	rows, err := s.source.QueryContext(ctx, query, since)
+	if err != nil {
+		return fmt.Errorf("error querying events: %s", err)
+	}
+	defer rows.Close()
 
-	for _, metric := range metricsBatch {
-		_, err = stmt.Exec(metric.Time, metric.Name, metric.Segment, metric.Value, metric.Comment)
+	send := func(evt Event) error {
+		select {
+		case events <- evt:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for rows.Next() {
+		var t, issueCreatedAt time.Time
+		var kind, issueKey, issueProject, issueType string
+		var issueSegment, statusFrom, statusTo, assigneeFrom, assigneeTo *string
+		err := rows.Scan(
+			&t,
+			&kind,
+			&issueKey,
+			&issueProject,
+			&issueType,
+			&issueSegment,
+			&statusFrom,
+			&statusTo,
+			&assigneeFrom,
+			&assigneeTo,
+			&issueCreatedAt,
+		)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("error reading events: %s", err)
 		}
+
+		var valueFrom, valueTo, rawStatusFrom, rawStatusTo string
+		var waiting bool
+		switch kind {
+		case StatusChanged:
+			if valueFrom, err = s.statusGroup(statusFrom); err != nil {
+				return fmt.Errorf("error mapping event for issue %s: %s", issueKey, err)
+			}
+			if valueTo, err = s.statusGroup(statusTo); err != nil {
+				return fmt.Errorf("error mapping event for issue %s: %s", issueKey, err)
+			}
+			if valueFrom == valueTo {
+				kind = StatusChangedInGroup
+			}
+			rawStatusFrom, rawStatusTo = toUnderscore(stringOrEmpty(statusFrom)), toUnderscore(stringOrEmpty(statusTo))
+			waiting = s.config.IsWaiting(stringOrEmpty(statusTo))
+		case IssueCreated:
+			// The synthesized event's values are taken from another
+			// event of the issue, which reports them if unmapped.
+			valueTo = "backlog"
+			if statusTo != nil {
+				valueTo = s.uncountedStatusGroup(*statusTo)
+			}
+			rawStatusTo = toUnderscore(stringOrEmpty(statusTo))
+			waiting = s.config.IsWaiting(stringOrEmpty(statusTo))
+		case AssigneeChanged:
+			valueFrom, valueTo = stringOrEmpty(assigneeFrom), stringOrEmpty(assigneeTo)
+		default:
+			continue
+		}
+
+		var issueTypeGroup string
+		if kind == IssueCreated {
+			issueTypeGroup = s.uncountedIssueTypeGroup(issueType)
+		} else if issueTypeGroup, err = s.issueTypeGroup(issueType); err != nil {
+			return fmt.Errorf("error mapping event for issue %s: %s", issueKey, err)
+		}
+		project := toUnderscore(issueProject)
+		evt := Event{
+			Time:           t,
+			Kind:           kind,
+			IssueKey:       issueKey,
+			IssueType:      issueTypeGroup,
+			Segment:        fmt.Sprintf("%s/%s", project, s.segment(issueSegment)),
+			ValueFrom:      valueFrom,
+			ValueTo:        valueTo,
+			StatusFrom:     rawStatusFrom,
+			StatusTo:       rawStatusTo,
+			Waiting:        waiting,
+			IssueCreatedAt: issueCreatedAt,
+		}
+
+		if previous, ok := issues[issueKey]; ok {
+			changes := []struct{ kind, from, to string }{
+				{IssueTypeChanged, previous.IssueType, evt.IssueType},
+				{SegmentChanged, previous.Segment, evt.Segment},
+			}
+			for _, c := range changes {
+				if c.from == c.to {
+					continue
+				}
+				change := evt
+				change.Kind, change.ValueFrom, change.ValueTo = c.kind, c.from, c.to
+				change.StatusFrom, change.StatusTo, change.Waiting = "", "", false
+				if err := send(change); err != nil {
+					return err
+				}
+			}
+		}
+		issues[issueKey] = evt
+
+		if err := send(evt); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading events: %s", err)
+	}
+	return nil
+}
+
+// lastIssueEvents returns the issue type group and segment of each
+// issue at its last event which happened at or before `since`, as an
+// event, so `StreamEvents` detects changes when resuming. Returns an
+// empty map if `since` is zero.
+//
+// Unmapped issue types are neither reported nor rejected in strict
+// mode: they were when these events were streamed.
+func (s *PGStore) lastIssueEvents(ctx context.Context, since time.Time) (map[string]Event, error) {
+	issues := make(map[string]Event)
+	if since.IsZero() {
+		return issues, nil
 	}
 
-	_, err = stmt.Exec()
+	query := fmt.Sprintf(`
+		SELECT DISTINCT ON (issue_key)
+			issue_key,
+			issue_project,
+			issue_type,
+			%[1]s AS issue_segment
+		FROM jira_issues_events
+		WHERE event_time <= $1 AND event_kind IN ('%[2]s', '%[3]s')
+		ORDER BY issue_key, event_time DESC
+		`, s.config.Segment.Column, StatusChanged, AssigneeChanged)
+	rows, err := s.source.QueryContext(ctx, query, since)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error querying last events: %s", err)
 	}
+	defer rows.Close()
 
-	err = stmt.Close()
+	for rows.Next() {
+		var issueKey, issueProject, issueType string
+		var issueSegment *string
+		if err := rows.Scan(&issueKey, &issueProject, &issueType, &issueSegment); err != nil {
+			return nil, fmt.Errorf("error reading last events: %s", err)
+		}
+		project := toUnderscore(issueProject)
+		issues[issueKey] = Event{
+			IssueKey:  issueKey,
+			IssueType: s.uncountedIssueTypeGroup(issueType),
+			Segment:   fmt.Sprintf("%s/%s", project, s.segment(issueSegment)),
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading last events: %s", err)
+	}
+	return issues, nil
+}
+
+// WriteMappingReport writes the values that did not match the
+// configuration, with the number of events they were found in, to the
+// `mapping_report` table (replacing the report of the previous run)
+// and logs a summary.
+//
+// Should be called once all events have been streamed.
+func (s *PGStore) WriteMappingReport() error {
+	count := 0
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		_, err := txn.Exec(`DELETE FROM "mapping_report"`)
+		if err != nil {
+			return err
+		}
+		for kind, values := range s.unmapped {
+			for value, n := range values {
+				count++
+				log.Printf("[store] unmapped %s: %s (%d events)\n", kind, value, n)
+				_, err = txn.Exec(`INSERT INTO "mapping_report" ("kind", "value", "count") VALUES ($1, $2, $3)`, kind, value, n)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("error in `WriteMappingReport`: %s", err)
 	}
 
-	err = txn.Commit()
+	log.Printf("[store] %d unmapped values reported\n", count)
+	return nil
+}
+
+// ReadCheckpoint returns the checkpoint saved by the last run, or
+// nil if there is none.
+func (s *PGStore) ReadCheckpoint() (*Checkpoint, error) {
+	rows, err := s.Query(`SELECT "generator", "state", "event_time" FROM "checkpoints"`)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error in `ReadCheckpoint`: %s", err)
 	}
+	defer rows.Close()
 
-	log.Printf("[store] %d metrics written\n", len(metricsBatch))
-	s.Add(-len(metricsBatch)) // mark done for all written metrics
+	var c *Checkpoint
+	for rows.Next() {
+		var generator, state string
+		var eventTime time.Time
+		if err := rows.Scan(&generator, &state, &eventTime); err != nil {
+			return nil, fmt.Errorf("error in `ReadCheckpoint`: %s", err)
+		}
+		if c == nil {
+			c = &Checkpoint{EventTime: eventTime, States: make(map[string][]byte)}
+		}
+		c.States[generator] = []byte(state)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error in `ReadCheckpoint`: %s", err)
+	}
+	return c, nil
 }
 
-// StreamEvents will generate a stream of `Event` records from
-// the database's `jira_issues_events` table, filtered with a `WHERE`
-// clause on the `segmentColumn` and `segmentValue`.
-//
-// TODO: remove segment filtering, process all, add project to segment
-func (s *PGStore) StreamEvents(segmentColumn, segmentValue string) chan Event {
-	events := make(chan Event, 0)
-
-	go func() {
-		query := fmt.Sprintf(`
-		SELECT 
-			event_time,
-			event_kind,
-			issue_key, 
-			issue_type,
-			issue_tribe,
-			status_change_from,
-			status_change_to,
-			assignee_change_from,
-			assignee_change_to,
-			issue_created_at
-		FROM jira_issues_events
-		WHERE %s = $1
-		ORDER BY event_time ASC
-		`, segmentColumn)
-		rows, err := s.Query(query, segmentValue)
+// writeCheckpoint replaces the saved checkpoint with `c` in the `txn`
+// transaction.
+func writeCheckpoint(txn *sql.Tx, c Checkpoint) error {
+	_, err := txn.Exec(`DELETE FROM "checkpoints"`)
+	if err != nil {
+		return err
+	}
+	for generator, state := range c.States {
+		_, err = txn.Exec(`INSERT INTO "checkpoints" ("generator", "state", "event_time") VALUES ($1, $2, $3)`, generator, string(state), c.EventTime)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var t, issueCreatedAt time.Time
-			var kind, issueKey, issueType string
-			var issueTribe, statusFrom, statusTo, assigneeFrom, assigneeTo *string
-			err := rows.Scan(
-				&t,
-				&kind,
-				&issueKey,
-				&issueType,
-				&issueTribe,
-				&statusFrom,
-				&statusTo,
-				&assigneeFrom,
-				&assigneeTo,
-				&issueCreatedAt,
-			)
+	}
+	return nil
+}
+
+// WriteForecasts replaces the forecasts of the previous run in the
+// `forecasts` table with `forecasts`.
+func (s *PGStore) WriteForecasts(forecasts []Forecast) error {
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		_, err := txn.Exec(`DELETE FROM "forecasts"`)
+		if err != nil {
+			return err
+		}
+		for _, f := range forecasts {
+			_, err = txn.Exec(`INSERT INTO "forecasts" ("time", "segment", "backlog_size", "percentile", "completion_date") VALUES ($1, $2, $3, $4, $5)`,
+				f.Time, f.Segment, f.BacklogSize, f.Percentile, f.CompletionDate)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in `WriteForecasts`: %s", err)
+	}
 
-			switch kind {
-			case "status_changed":
-				statusGroupFrom := statusGroup(statusFrom)
-				statusGroupTo := statusGroup(statusTo)
-				if statusGroupFrom != statusGroupTo {
-					events <- Event{
-						Time:           t,
-						Kind:           kind,
-						IssueKey:       issueKey,
-						IssueType:      issueTypeGroup(issueType),
-						Segment:        segment(issueTribe),
-						ValueFrom:      statusGroupFrom,
-						ValueTo:        statusGroupTo,
-						IssueCreatedAt: issueCreatedAt,
-					}
-				}
+	log.Printf("[store] %d forecasts written\n", len(forecasts))
+	return nil
+}
+
+// WriteAnomalies replaces the anomalies of the previous audit in the
+// `data_quality` table with `anomalies`.
+func (s *PGStore) WriteAnomalies(anomalies []Anomaly) error {
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		_, err := txn.Exec(`DELETE FROM "data_quality"`)
+		if err != nil {
+			return err
+		}
+		stmt, err := txn.Prepare(pq.CopyIn("data_quality", "time", "kind", "issue_key", "description"))
+		if err != nil {
+			return err
+		}
+		for _, a := range anomalies {
+			_, err = stmt.Exec(a.Time, a.Kind, a.IssueKey, a.Description)
+			if err != nil {
+				stmt.Close()
+				return err
 			}
 		}
-		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+		_, err = stmt.Exec()
+		if err != nil {
+			stmt.Close()
+			return err
 		}
-		close(events)
-	}()
+		return stmt.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("error in `WriteAnomalies`: %s", err)
+	}
 
-	return events
+	log.Printf("[store] %d anomalies written\n", len(anomalies))
+	return nil
 }
 
-// CreateTables creates the `metrics` table.
-func (s *PGStore) CreateTables() {
+// CreateTables creates the `metrics`, `mapping_report`, `checkpoints`,
+// `forecasts` and `data_quality` tables if they don't exist.
+func (s *PGStore) CreateTables() error {
 	queries := []string{
-		`CREATE TABLE "metrics" (
+		createMetricsTableQuery(MetricsTable),
+		`CREATE TABLE IF NOT EXISTS "mapping_report" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"kind" TEXT NOT NULL,
+			"value" TEXT NOT NULL,
+			"count" INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS "checkpoints" (
+			"generator" TEXT PRIMARY KEY NOT NULL,
+			"state" TEXT NOT NULL,
+			"event_time" TIMESTAMP(6) NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS "forecasts" (
 			"id" SERIAL PRIMARY KEY NOT NULL,
 			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
 			"time" TIMESTAMP(6) NOT NULL,
-			"name" TEXT,
-			"segment" TEXT,
-			"value" DOUBLE PRECISION,
-			"comment" TEXT
+			"segment" TEXT NOT NULL,
+			"backlog_size" INTEGER NOT NULL,
+			"percentile" INTEGER NOT NULL,
+			"completion_date" TIMESTAMP(6) NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS "data_quality" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"time" TIMESTAMP(6) NOT NULL,
+			"kind" TEXT NOT NULL,
+			"issue_key" TEXT NOT NULL,
+			"description" TEXT NOT NULL
+		);`,
+	}
+	err := s.exec(queries)
+	if err != nil {
+		return fmt.Errorf("error in `CreateTables`: %s", err)
+	}
+	return nil
+}
+
+// UseStagingTable (re)creates the `metrics_new` staging table and
+// makes the store write metrics to it instead of `metrics`. Must be
+// called before any metric is written.
+func (s *PGStore) UseStagingTable() error {
+	queries := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, StagingMetricsTable),
+		createMetricsTableQuery(StagingMetricsTable),
 	}
 	err := s.exec(queries)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `CreateTables`: %s", err))
+		return fmt.Errorf("error in `UseStagingTable`: %s", err)
 	}
+	s.metricsTable = StagingMetricsTable
+	return nil
+}
+
+// SwapStagingTable replaces the `metrics` table with the `metrics_new`
+// staging table and the saved checkpoint with `c` in a single
+// transaction, so readers never see a missing or partial table and the
+// checkpoint always matches the metrics. Must be called once all
+// metrics have been written (see `DoneAndWait`).
+func (s *PGStore) SwapStagingTable(c Checkpoint) error {
+	queries := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, MetricsTable),
+		fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s";`, StagingMetricsTable, MetricsTable),
+		// Renaming the serial sequence and primary key index so the names
+		// are available for the next staging table.
+		fmt.Sprintf(`ALTER SEQUENCE IF EXISTS "%s_id_seq" RENAME TO "%s_id_seq";`, StagingMetricsTable, MetricsTable),
+		fmt.Sprintf(`ALTER INDEX IF EXISTS "%s_pkey" RENAME TO "%s_pkey";`, StagingMetricsTable, MetricsTable),
+	}
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		for _, q := range queries {
+			if _, err := txn.Exec(q); err != nil {
+				return err
+			}
+		}
+		return writeCheckpoint(txn, c)
+	})
+	if err != nil {
+		return fmt.Errorf("error in `SwapStagingTable`: %s", err)
+	}
+
+	s.metricsTable = MetricsTable
+	log.Printf("[store] `%s` table replaced with `%s`, checkpoint written at %s\n", MetricsTable, StagingMetricsTable, c.EventTime.Format(time.RFC3339))
+	return nil
+}
+
+// AppendStagingTable appends the metrics of the `metrics_new` staging
+// table to the `metrics` table, drops the staging table and replaces
+// the saved checkpoint with `c` in a single transaction, so the
+// appended metrics and the checkpoint are never out of sync. Must be
+// called once all metrics have been written (see `DoneAndWait`).
+func (s *PGStore) AppendStagingTable(c Checkpoint) error {
+	queries := []string{
+		fmt.Sprintf(`INSERT INTO "%s" ("time", "name", "segment", "value", "comment") SELECT "time", "name", "segment", "value", "comment" FROM "%s" ORDER BY "id";`, MetricsTable, StagingMetricsTable),
+		fmt.Sprintf(`DROP TABLE "%s";`, StagingMetricsTable),
+	}
+	err := s.inTransaction(func(txn *sql.Tx) error {
+		for _, q := range queries {
+			if _, err := txn.Exec(q); err != nil {
+				return err
+			}
+		}
+		return writeCheckpoint(txn, c)
+	})
+	if err != nil {
+		return fmt.Errorf("error in `AppendStagingTable`: %s", err)
+	}
+
+	s.metricsTable = MetricsTable
+	log.Printf("[store] `%s` table appended to `%s`, checkpoint written at %s\n", StagingMetricsTable, MetricsTable, c.EventTime.Format(time.RFC3339))
+	return nil
 }
 
 // DropTables drops the tables used by this source
-// (`jira_issues_events` and `jira_issues_states`)
-func (s *PGStore) DropTables() {
+// (`metrics`, `metrics_new`, `mapping_report`, `checkpoints`,
+// `forecasts` and `data_quality`)
+func (s *PGStore) DropTables() error {
 	queries := []string{
-		`DROP TABLE IF EXISTS "metrics";`,
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, MetricsTable),
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, StagingMetricsTable),
+		`DROP TABLE IF EXISTS "mapping_report";`,
+		`DROP TABLE IF EXISTS "checkpoints";`,
+		`DROP TABLE IF EXISTS "forecasts";`,
+		`DROP TABLE IF EXISTS "data_quality";`,
 	}
 	err := s.exec(queries)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `DropTables()`: %s", err))
+		return fmt.Errorf("error in `DropTables()`: %s", err)
 	}
+	return nil
+}
+
+func createMetricsTableQuery(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"time" TIMESTAMP(6) NOT NULL,
+			"name" TEXT,
+			"segment" TEXT,
+			"value" DOUBLE PRECISION,
+			"comment" TEXT
+		);`, table)
+}
+
+// inTransaction runs `f` in a transaction, which is committed if `f`
+// succeeds and rolled back otherwise.
+func (s *PGStore) inTransaction(f func(txn *sql.Tx) error) error {
+	txn, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
 }
 
 // exec executes the passed SQL commands on the DB using `Exec`.
@@ -258,123 +710,80 @@ func (s *PGStore) exec(cmds []string) (err error) {
  * ============================= */
 
 // statusGroup maps the Jira status to a simpler status used for
-// metrics.
+// metrics, using the configured status groups.
 //
 // Currently, statuses used in metrics are:
-// backlog, wip, done, released.
-func statusGroup(status *string) string {
+// backlog, wip, done, resolved.
+func (s *PGStore) statusGroup(status *string) (string, error) {
 	if status == nil {
-		return ""
+		return "", nil
 	}
-	groups := map[string][]string{
-		"backlog": []string{
-			"Wait/Watch",
-			"Open",
-			"Ready",
-			"Selected for spec",
-			"To Price",
-			"To Do",
-			"Reopened",
-			"ToDo",
-			"In Preparation",
-			"Ready for development",
-			"Ready for sprint",
-			"Selected for Development",
-			"Open / Ready for dev",
-			"Backlog",
-		},
-		"wip": []string{
-			"To be tested",
-			"Developed",
-			"Waiting for validation",
-			"In Staging",
-			"Ready for Testing",
-			"In Spec Review",
-			"Tech review",
-			"In Spec",
-			"Quality check",
-			"Technical review",
-			"Développement",
-			"Release for Review",
-			"Stand-by",
-			"Ready for Review",
-			"In Development",
-			"In Progress",
-			"Functional Review",
-			"Pending",
-			"Pemding",
-			"In Testing",
-			"Ready for Staging",
-			"To validate",
-			"In Review",
-			"In Design",
-			"In Dev",
-			"In Functional Review",
-		},
-		"done": []string{
-			"To announce",
-			"Ready for deploy",
-			"To be released",
-			"Functional GO",
-			"Ready for Release",
-		},
-		"resolved": []string{
-			"Closed",
-			"Canceled",
-			"Terminé",
-			"Done",
-			"Released",
-			"Resolved",
-		},
-	}
-	for group, statuses := range groups {
-		if slices.StringsContain(statuses, *status) {
-			return group
-		}
+	group, ok := s.config.StatusGroup(*status)
+	if !ok {
+		return s.unmappedValue("status", *status)
 	}
-	log.Fatalf("Status did not match any group: %s", *status)
-	return ""
+	return group, nil
 }
 
-func issueTypeGroup(issueType string) string {
-	usIssueType := toUnderscore(issueType)
-	groups := map[string][]string{
-		"product": []string{
-			"epic",
-			"spec",
-			"improvement",
-			"story",
-			"new_feature",
-		},
-		"ops": []string{
-			"sso_launch",
-			"task",
-		},
-		"technical": []string{
-			"technical_task",
-			"sub_task",
-		},
-		"bug": []string{
-			"bug",
-		},
-	}
-	for group, types := range groups {
-		if slices.StringsContain(types, usIssueType) {
-			return group
-		}
+// issueTypeGroup maps the Jira issue type to the configured issue
+// type group.
+func (s *PGStore) issueTypeGroup(issueType string) (string, error) {
+	group, ok := s.config.IssueTypeGroup(toUnderscore(issueType))
+	if !ok {
+		return s.unmappedValue("issue_type", issueType)
 	}
-	log.Fatalf("Status did not match any group: %s", issueType)
-	return ""
+	return group, nil
 }
 
-func segment(issueTribe *string) string {
-	tribe := "none"
-	if issueTribe != nil {
-		tribe = *issueTribe
+// unmappedValue handles a `value` of the specified `kind` which did
+// not match any group: fails if the store is strict, otherwise counts
+// it and returns the `Unmapped` group.
+// uncountedStatusGroup returns the group of `status`, or `Unmapped`,
+// without reporting it nor failing in strict mode.
+func (s *PGStore) uncountedStatusGroup(status string) string {
+	if group, ok := s.config.StatusGroup(status); ok {
+		return group
 	}
-	usTribe := toUnderscore(tribe)
+	return Unmapped
+}
+
+// uncountedIssueTypeGroup returns the group of `issueType`, or
+// `Unmapped`, without reporting it nor failing in strict mode.
+func (s *PGStore) uncountedIssueTypeGroup(issueType string) string {
+	if group, ok := s.config.IssueTypeGroup(toUnderscore(issueType)); ok {
+		return group
+	}
+	return Unmapped
+}
 
-	return fmt.Sprintf("tribe_%s", usTribe)
+func (s *PGStore) unmappedValue(kind, value string) (string, error) {
+	if s.strict {
+		return "", fmt.Errorf("%s did not match any group: %s", kind, value)
+	}
+	if _, ok := s.unmapped[kind]; !ok {
+		s.unmapped[kind] = make(map[string]int)
+	}
+	s.unmapped[kind][value]++
+	return Unmapped, nil
+}
+
+// segment returns the segment built from the value of the
+// configured segment column.
+func (s *PGStore) segment(value *string) string {
+	v := "none"
+	if value != nil {
+		v = *value
+	}
+	usValue := toUnderscore(v)
+
+	return fmt.Sprintf("%s_%s", s.config.Segment.Prefix, usValue)
+}
+
+func stringOrEmpty(str *string) string {
+	if str == nil {
+		return ""
+	}
+	return *str
 }
 
 func toUnderscore(str string) string {
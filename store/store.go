@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Kinds of events.
+const (
+	// StatusChanged events have the issue's previous and new status
+	// groups in `ValueFrom` and `ValueTo`.
+	StatusChanged = "status_changed"
+	// StatusChangedInGroup events are status changes within the
+	// same status group (e.g. from "In Development" to "In Review"),
+	// the group being in both `ValueFrom` and `ValueTo`.
+	StatusChangedInGroup = "status_changed_in_group"
+	// IssueCreated events are synthesized at the issue's creation,
+	// with its initial status group in `ValueTo` (`backlog` if the
+	// issue never changed status).
+	IssueCreated = "issue_created"
+	// IssueTypeChanged events are synthesized when an issue's type
+	// group changes, with the previous and new groups in `ValueFrom`
+	// and `ValueTo`.
+	IssueTypeChanged = "issue_type_changed"
+	// SegmentChanged events are synthesized when an issue moves to
+	// another segment, with the previous and new segments in
+	// `ValueFrom` and `ValueTo`.
+	SegmentChanged = "segment_changed"
+	// AssigneeChanged events have the issue's previous and new
+	// assignees in `ValueFrom` and `ValueTo` (empty if unassigned).
+	AssigneeChanged = "assignee_changed"
+)
+
+// EventSource is the interface of stores providing the events
+// metrics are generated from.
+type EventSource interface {
+	// StreamEvents sends the events which happened after `since` to
+	// `events`, ordered by time ascending, and closes it when done.
+	// Returns early with an error if the events can't be read or if
+	// `ctx` is cancelled.
+	StreamEvents(ctx context.Context, since time.Time, events chan<- Event) error
+}
+
+// MetricWriter is the interface of stores generated metrics are
+// written to.
+type MetricWriter interface {
+	WriteMetric(metric Metric) error
+}
+
+// Metric represents a metric to be stored to the DB.
+type Metric struct {
+	Time    time.Time
+	Name    string
+	Segment string
+	Value   float64
+	Comment string
+}
+
+// Event represents the Event loaded from the database,
+// generated by [Jira Source]() (from the
+// `jira_issues_events` table).
+//
+// `Segment` is made of the issue's project and segment
+// (e.g. `jobteaser/tribe_x`).
+//
+// For status changes, `StatusFrom` and `StatusTo` are the Jira
+// statuses, underscored (e.g. `in_review`), and `Waiting` is true if
+// the new status is a waiting status (see `config.Config.IsWaiting`).
+type Event struct {
+	Time           time.Time
+	Kind           string
+	IssueKey       string
+	IssueType      string
+	Segment        string
+	ValueFrom      string
+	ValueTo        string
+	StatusFrom     string
+	StatusTo       string
+	Waiting        bool
+	IssueCreatedAt time.Time
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("{EVENT:%s - %s - issue:%s - from:%s - to:%s}", e.Kind, e.Time.Format(time.RFC3339), e.IssueKey, e.ValueFrom, e.ValueTo)
+}
+
+// Forecast represents the forecasted completion date of the issues
+// in a segment's backlog, for a percentile of the simulations (e.g.
+// 85% of the simulations completed the backlog by `CompletionDate`).
+type Forecast struct {
+	Time           time.Time // day the forecast starts from
+	Segment        string
+	BacklogSize    int
+	Percentile     int
+	CompletionDate time.Time
+}
+
+// Kinds of anomalies.
+const (
+	StatusMismatch       = "status_mismatch"
+	NegativeCounter      = "negative_counter"
+	OutOfOrder           = "out_of_order"
+	EventAfterResolution = "event_after_resolution"
+	UnmappedValue        = "unmapped_value"
+)
+
+// Anomaly represents a data quality problem found in the events
+// of an issue.
+type Anomaly struct {
+	Time        time.Time
+	Kind        string
+	IssueKey    string
+	Description string
+}
+
+// Checkpoint represents the state of the metrics generators
+// after processing the events until `EventTime`.
+type Checkpoint struct {
+	EventTime time.Time         // time of the last processed event
+	States    map[string][]byte // generator name -> state
+}